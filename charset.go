@@ -0,0 +1,76 @@
+package znets
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	CharsetNone     = "none" //不做转换，原始字节透传
+	CharsetGBK      = "gbk"
+	CharsetGB18030  = "gb18030"
+	CharsetBig5     = "big5"
+	CharsetShiftJIS = "shift-jis"
+	CharsetUTF16LE  = "utf-16le"
+	CharsetUTF16BE  = "utf-16be"
+)
+
+//基于transform的字符集转换，每次转换创建新的Transformer，可在多个连接间共享
+type transformCharset struct {
+	decoder func() transform.Transformer
+	encoder func() transform.Transformer
+}
+
+//通过 golang.org/x/text 的编码创建字符集转换
+func NewCharset(enc encoding.Encoding) ICharset {
+	return &transformCharset{
+		decoder: func() transform.Transformer { return enc.NewDecoder() },
+		encoder: func() transform.Transformer { return enc.NewEncoder() },
+	}
+}
+
+//通过自定义Transformer创建字符集转换
+func NewTransformCharset(decoder, encoder func() transform.Transformer) ICharset {
+	return &transformCharset{
+		decoder: decoder,
+		encoder: encoder,
+	}
+}
+
+func (t *transformCharset) Decode(data []byte) ([]byte, error) {
+	res, _, err := transform.Bytes(t.decoder(), data)
+	return res, err
+}
+
+func (t *transformCharset) Encode(data []byte) ([]byte, error) {
+	res, _, err := transform.Bytes(t.encoder(), data)
+	return res, err
+}
+
+//通过名称获取字符集转换，none返回nil
+func GetCharset(name string) (ICharset, error) {
+	switch strings.ToLower(name) {
+	case CharsetNone:
+		return nil, nil
+	case CharsetGBK:
+		return NewCharset(simplifiedchinese.GBK), nil
+	case CharsetGB18030:
+		return NewCharset(simplifiedchinese.GB18030), nil
+	case CharsetBig5:
+		return NewCharset(traditionalchinese.Big5), nil
+	case CharsetShiftJIS:
+		return NewCharset(japanese.ShiftJIS), nil
+	case CharsetUTF16LE:
+		return NewCharset(unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)), nil
+	case CharsetUTF16BE:
+		return NewCharset(unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %s", name)
+}
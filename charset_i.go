@@ -0,0 +1,7 @@
+package znets
+
+//通讯数据字符集转换
+type ICharset interface {
+	Decode([]byte) ([]byte, error) //对端编码转为UTF-8
+	Encode([]byte) ([]byte, error) //UTF-8转为对端编码
+}
@@ -0,0 +1,535 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zhlin160/znets"
+)
+
+const (
+	DEFAULT_DIAL_TIMEOUT           = 10 * time.Second
+	DEFAULT_WRITE_TIMEOUT          = 10 * time.Second
+	DEFAULT_REQUEST_TIMEOUT        = 10 * time.Second
+	DEFAULT_RECONNECT_INTERVAL     = time.Second
+	DEFAULT_RECONNECT_MAX_INTERVAL = 30 * time.Second
+
+	readBufferSize   = 65535
+	EVENT_QUEUE_SIZE = 1024 //等待回调的事件数量，队列满时暂停读取
+)
+
+var (
+	ErrNotConnected = errors.New("client is not connected")
+	ErrClosed       = errors.New("client is closed")
+)
+
+//客户端设置
+type Options struct {
+	Network      string         //tcp|tcp4|tcp6|unix，默认tcp
+	Address      string         //服务端地址，如 127.0.0.1:9503
+	Codec        znets.ICodec   //协议编解码，与服务端一致，nil表示不做协议解析
+	Charset      znets.ICharset //字符集转换，nil表示原始字节透传
+	TLS          *tls.Config    //TLS设置，nil表示不启用
+	DialTimeout  time.Duration  //连接超时时间，默认10s
+	WriteTimeout time.Duration  //写超时时间，默认10s
+
+	Reconnect            bool          //断开后自动重连
+	ReconnectInterval    time.Duration //首次重连间隔，之后按指数增长，默认1s
+	ReconnectMaxInterval time.Duration //最大重连间隔，默认30s
+	MaxRetries           int           //连续重连的最大次数，0表示不限制
+
+	Heartbeat         *znets.Heartbeat //心跳，与服务端一致，nil表示不启用
+	HeartbeatInterval time.Duration    //写空闲超过该时间发送ping，连续MaxMiss个间隔没有收到数据则断开
+
+	RequestTimeout time.Duration             //Request的默认超时时间，默认10s
+	ReplyId        func(msgId uint32) uint32 //请求消息id对应的响应消息id，nil表示与请求消息id相同
+}
+
+//等待响应的请求
+type pendingCall struct {
+	seq     uint32
+	replyId uint32
+	ch      chan znets.IMessage
+}
+
+//TCP客户端，与服务端共用协议编解码
+type Client struct {
+	options *Options
+	event   IEvent
+
+	conn      net.Conn
+	connLock  sync.RWMutex
+	writeLock sync.Mutex
+
+	pending     []*pendingCall //等待响应的请求，按发送顺序排列
+	pendingLock sync.Mutex
+	seq         uint32 //Request分配的序号
+	supportSeq  bool   //协议能否携带seq，不支持时按消息id匹配响应
+
+	lastReadTime  int64
+	lastWriteTime int64
+	activeLock    sync.Mutex
+
+	events chan func() //事件回调按顺序在单独的协程中执行，不阻塞读取
+
+	closed   bool
+	exitChan chan struct{}
+}
+
+//连接服务端，首次连接失败直接返回错误
+func Dial(options *Options, event IEvent) (*Client, error) {
+	opts := *options
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Codec == nil {
+		opts.Codec = znets.NewRawCodec()
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DEFAULT_WRITE_TIMEOUT
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = DEFAULT_RECONNECT_INTERVAL
+	}
+	if opts.ReconnectMaxInterval <= 0 {
+		opts.ReconnectMaxInterval = DEFAULT_RECONNECT_MAX_INTERVAL
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+
+	seqCodec, ok := opts.Codec.(znets.ISeqCodec)
+	c := &Client{
+		options:    &opts,
+		event:      event,
+		supportSeq: ok && seqCodec.SupportSeq(),
+		events:     make(chan func(), EVENT_QUEUE_SIZE),
+		exitChan:   make(chan struct{}),
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	go c.runEvents()
+	c.serve(conn)
+
+	if opts.Heartbeat != nil && opts.HeartbeatInterval > 0 {
+		go c.keepalive()
+	}
+	return c, nil
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.options.DialTimeout}
+	if c.options.TLS != nil {
+		return tls.DialWithDialer(dialer, c.options.Network, c.options.Address, c.options.TLS)
+	}
+	return dialer.Dial(c.options.Network, c.options.Address)
+}
+
+//使用新建立的连接开始读取，OnConnect在读取协程启动后回调，其中可以调用Request
+func (c *Client) serve(conn net.Conn) {
+	c.connLock.Lock()
+	c.conn = conn
+	c.connLock.Unlock()
+	c.touch(true, true)
+
+	//先放入OnConnect，保证在该连接的OnMessage之前回调
+	c.emit(func() {
+		c.event.OnConnect(c)
+	})
+	go c.reader(conn)
+}
+
+//放入事件回调队列
+func (c *Client) emit(fn func()) {
+	if c.event != nil {
+		c.events <- fn
+	}
+}
+
+//按顺序执行事件回调，读取协程不再使用时退出
+func (c *Client) runEvents() {
+	for fn := range c.events {
+		fn()
+	}
+}
+
+//读取数据，断开后按设置重连，不再重连时结束事件回调
+func (c *Client) reader(conn net.Conn) {
+	err := c.readLoop(conn)
+
+	c.connLock.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	closed := c.closed
+	c.connLock.Unlock()
+	conn.Close()
+	c.failPending()
+
+	if closed {
+		err = nil
+	}
+	c.emit(func() {
+		c.event.OnClose(c, err)
+	})
+	if !closed && c.options.Reconnect && c.reconnect() {
+		return
+	}
+	close(c.events)
+}
+
+func (c *Client) readLoop(conn net.Conn) error {
+	buff := make([]byte, 0, readBufferSize)
+	for {
+		if len(buff) == cap(buff) {
+			newBuff := make([]byte, len(buff), 2*cap(buff))
+			copy(newBuff, buff)
+			buff = newBuff
+		}
+		n, err := conn.Read(buff[len(buff):cap(buff)])
+		if err != nil {
+			return err
+		}
+		buff = buff[:len(buff)+n]
+		c.touch(true, false)
+
+		start := 0
+		for start < len(buff) {
+			msg, consumed, err := c.options.Codec.Decode(buff[start:])
+			if err != nil {
+				return err
+			}
+			if consumed == 0 {
+				break
+			}
+			start += consumed
+			c.dispatch(msg)
+		}
+		buff = buff[:copy(buff, buff[start:])]
+	}
+}
+
+//分发消息：心跳 -> 等待的请求 -> 事件回调
+func (c *Client) dispatch(msg znets.IMessage) {
+	if c.options.Charset != nil {
+		if data, err := c.options.Charset.Decode(msg.GetData()); err == nil {
+			msg.SetData(data)
+		}
+	}
+
+	if hb := c.options.Heartbeat; hb != nil {
+		if msg.GetId() == hb.PingId && bytes.Equal(msg.GetData(), hb.PingData) {
+			c.SendMessage(&znets.Message{Id: hb.PongId, Data: hb.PongData, Length: uint32(len(hb.PongData))})
+			return
+		}
+		if msg.GetId() == hb.PongId && bytes.Equal(msg.GetData(), hb.PongData) {
+			return
+		}
+	}
+
+	if pc, isReply := c.popPending(msg); pc != nil {
+		pc.ch <- msg
+		return
+	} else if isReply {
+		return //请求已超时
+	}
+	c.emit(func() {
+		c.event.OnMessage(c, msg)
+	})
+}
+
+//按指数退避重连，主动关闭或超过最大次数时停止，返回是否重连成功
+func (c *Client) reconnect() bool {
+	interval := c.options.ReconnectInterval
+	for retries := 1; c.options.MaxRetries == 0 || retries <= c.options.MaxRetries; retries++ {
+		//增加随机抖动，避免大量客户端同时重连
+		delay := interval/2 + time.Duration(rand.Int63n(int64(interval)))
+		select {
+		case <-time.After(delay):
+		case <-c.exitChan:
+			return false
+		}
+
+		conn, err := c.dial()
+		if err == nil {
+			c.connLock.Lock()
+			if c.closed {
+				c.connLock.Unlock()
+				conn.Close()
+				return false
+			}
+			c.connLock.Unlock()
+			c.serve(conn)
+			return true
+		}
+
+		interval *= 2
+		if interval > c.options.ReconnectMaxInterval {
+			interval = c.options.ReconnectMaxInterval
+		}
+	}
+	return false
+}
+
+//写空闲时发送ping，长时间没有收到数据时断开连接
+func (c *Client) keepalive() {
+	hb := c.options.Heartbeat
+	ticker := time.NewTicker(c.options.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			readIdle, writeIdle := c.idle()
+			if hb.MaxMiss > 0 && readIdle >= time.Duration(hb.MaxMiss)*c.options.HeartbeatInterval {
+				c.connLock.RLock()
+				if c.conn != nil {
+					c.conn.Close()
+				}
+				c.connLock.RUnlock()
+				continue
+			}
+			if writeIdle >= c.options.HeartbeatInterval {
+				c.SendMessage(&znets.Message{Id: hb.PingId, Data: hb.PingData, Length: uint32(len(hb.PingData))})
+			}
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+//发送数据，消息id为0
+func (c *Client) Send(data []byte) error {
+	return c.SendMessage(&znets.Message{Data: data, Length: uint32(len(data))})
+}
+
+//发送消息，经过协议编码后写入
+func (c *Client) SendMessage(msg znets.IMessage) error {
+	payload := msg.GetData()
+	if c.options.Charset != nil {
+		encoded, err := c.options.Charset.Encode(payload)
+		if err != nil {
+			return err
+		}
+		payload = encoded
+	}
+	data, err := c.options.Codec.Encode(&znets.Message{
+		Id:     msg.GetId(),
+		Seq:    msg.GetSeq(),
+		Length: uint32(len(payload)),
+		Data:   payload,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	c.connLock.RLock()
+	conn := c.conn
+	closed := c.closed
+	c.connLock.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	if _, err := conn.Write(data); err != nil {
+		conn.Close()
+		return err
+	}
+	c.touch(false, true)
+	return nil
+}
+
+//发送请求并等待响应，协议支持seq时请求分配seq，按响应的seq匹配
+//协议不支持seq时按消息id匹配，同一消息id的请求按发送顺序匹配响应
+//ctx没有设置超时时间时使用RequestTimeout
+func (c *Client) Request(ctx context.Context, msg znets.IMessage) (znets.IMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.RequestTimeout)
+		defer cancel()
+	}
+
+	replyId := msg.GetId()
+	if c.options.ReplyId != nil {
+		replyId = c.options.ReplyId(replyId)
+	}
+	pc, err := c.addPending(replyId)
+	if err != nil {
+		return nil, err
+	}
+	defer c.removePending(pc)
+
+	err = c.SendMessage(&znets.Message{
+		Id:     msg.GetId(),
+		Seq:    pc.seq,
+		Length: uint32(len(msg.GetData())),
+		Data:   msg.GetData(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-pc.ch:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//回复服务端通过Call发送的请求，响应消息使用相同的消息id及seq
+func (c *Client) Reply(request znets.IMessage, data []byte) error {
+	seq := request.GetSeq()
+	if seq != 0 {
+		seq |= znets.SEQ_REPLY
+	}
+	return c.SendMessage(&znets.Message{
+		Id:     request.GetId(),
+		Seq:    seq,
+		Length: uint32(len(data)),
+		Data:   data,
+	})
+}
+
+//分配seq并登记等待响应的请求，跳过仍在等待响应的seq，seq全部在使用时返回ErrTooManyCalls
+func (c *Client) addPending(replyId uint32) (*pendingCall, error) {
+	pc := &pendingCall{replyId: replyId, ch: make(chan znets.IMessage, 1)}
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	if len(c.pending) >= znets.MAX_SEQ {
+		return nil, znets.ErrTooManyCalls
+	}
+	for pc.seq == 0 {
+		c.seq = c.seq%znets.MAX_SEQ + 1
+		pc.seq = c.seq
+		for _, p := range c.pending {
+			if p.seq == pc.seq {
+				pc.seq = 0
+				break
+			}
+		}
+	}
+	c.pending = append(c.pending, pc)
+	return pc, nil
+}
+
+//取出响应对应的请求，协议支持seq时只匹配带响应标记且seq一致的消息，不支持时取最早等待该消息id的请求
+//协议支持seq时没有响应标记的是服务端的Call请求或推送，即使消息id相同也不作为响应
+func (c *Client) popPending(msg znets.IMessage) (*pendingCall, bool) {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	isReply := c.supportSeq && msg.GetSeq()&znets.SEQ_REPLY != 0
+	byId := !c.supportSeq
+	for i, p := range c.pending {
+		if (isReply && p.seq == msg.GetSeq()&^znets.SEQ_REPLY) || (byId && p.replyId == msg.GetId()) {
+			c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+			return p, true
+		}
+	}
+	return nil, isReply
+}
+
+func (c *Client) removePending(pc *pendingCall) {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	for i, p := range c.pending {
+		if p == pc {
+			c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+//连接断开时等待中的请求全部失败
+func (c *Client) failPending() {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	for _, p := range c.pending {
+		close(p.ch)
+	}
+	c.pending = nil
+}
+
+//更新最后读写时间
+func (c *Client) touch(read bool, write bool) {
+	now := time.Now().UnixNano()
+	c.activeLock.Lock()
+	defer c.activeLock.Unlock()
+
+	if read {
+		c.lastReadTime = now
+	}
+	if write {
+		c.lastWriteTime = now
+	}
+}
+
+//读空闲及写空闲时间
+func (c *Client) idle() (time.Duration, time.Duration) {
+	now := time.Now().UnixNano()
+	c.activeLock.Lock()
+	defer c.activeLock.Unlock()
+
+	return time.Duration(now - c.lastReadTime), time.Duration(now - c.lastWriteTime)
+}
+
+//是否已连接
+func (c *Client) IsConnected() bool {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	return c.conn != nil
+}
+
+//服务端地址，未连接时返回nil
+func (c *Client) RemoteAddr() net.Addr {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
+
+//关闭连接，不再重连
+func (c *Client) Close() error {
+	c.connLock.Lock()
+	if c.closed {
+		c.connLock.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	conn := c.conn
+	c.connLock.Unlock()
+
+	close(c.exitChan)
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package client
+
+import "github.com/zhlin160/znets"
+
+//客户端事件回调，按顺序在单独的协程中执行，不阻塞读取，回调中可以调用Request
+//事件队列满时暂停读取，回调中长时间阻塞会影响Request接收响应
+type IEvent interface {
+	OnConnect(*Client)                 //连接成功，包括重连成功
+	OnMessage(*Client, znets.IMessage) //收到消息，心跳及Request的响应不会回调
+	OnClose(*Client, error)            //连接断开，主动关闭时error为nil
+}
@@ -0,0 +1,181 @@
+package znets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ClientIdAddress   = "address"   //地址及连接id的hex编码，默认方式
+	ClientIdHMAC      = "hmac"      //HMAC签名，不可伪造
+	ClientIdRandom    = "random"    //128位随机数
+	ClientIdSnowflake = "snowflake" //雪花算法，包含节点id
+)
+
+var ErrInvalidClientId = errors.New("invalid clientId")
+
+//通过名称创建clientId生成器
+func NewClientIdGenerator(name string, secret string, nodeId int64) (IClientIdGenerator, error) {
+	switch name {
+	case "", ClientIdAddress:
+		return NewAddressClientIdGenerator(), nil
+	case ClientIdHMAC:
+		if secret == "" {
+			return nil, errors.New("hmac clientId requires a secret")
+		}
+		return NewHMACClientIdGenerator([]byte(secret)), nil
+	case ClientIdRandom:
+		return NewRandomClientIdGenerator(), nil
+	case ClientIdSnowflake:
+		return NewSnowflakeClientIdGenerator(nodeId)
+	}
+	return nil, errors.New("unsupported clientId generator " + name)
+}
+
+//地址及连接id的hex编码，兼容旧版本的clientId，会暴露客户端地址
+type addressClientId struct{}
+
+func NewAddressClientIdGenerator() IClientIdGenerator {
+	return addressClientId{}
+}
+
+func (addressClientId) Generate(c IConnection) string {
+	return hex.EncodeToString([]byte(c.RemoteAddr().String() + ":" + strconv.Itoa(int(c.GetID()))))
+}
+
+func (addressClientId) Validate(clientId string) error {
+	_, _, err := ClientIdToAddress(clientId)
+	return err
+}
+
+//解析address方式的clientId，返回客户端地址及连接id，IPv6地址带方括号如 [::1]:9503
+func ClientIdToAddress(clientId string) (addr string, connId uint32, err error) {
+	data, err := hex.DecodeString(clientId)
+	if err != nil {
+		return "", 0, ErrInvalidClientId
+	}
+	index := strings.LastIndex(string(data), ":")
+	if index <= 0 {
+		return "", 0, ErrInvalidClientId
+	}
+	id, err := strconv.ParseUint(string(data[index+1:]), 10, 32)
+	if err != nil {
+		return "", 0, ErrInvalidClientId
+	}
+	return string(data[:index]), uint32(id), nil
+}
+
+//HMAC签名的clientId，格式 hex(随机数8字节 + 连接id4字节 + 签名16字节)
+type hmacClientId struct {
+	secret []byte
+}
+
+func NewHMACClientIdGenerator(secret []byte) IClientIdGenerator {
+	return &hmacClientId{secret: secret}
+}
+
+func (h *hmacClientId) Generate(c IConnection) string {
+	payload := make([]byte, 12, 28)
+	rand.Read(payload[:8])
+	binary.BigEndian.PutUint32(payload[8:], c.GetID())
+	return hex.EncodeToString(append(payload, h.sign(payload)...))
+}
+
+func (h *hmacClientId) Validate(clientId string) error {
+	data, err := hex.DecodeString(clientId)
+	if err != nil || len(data) != 28 {
+		return ErrInvalidClientId
+	}
+	if !hmac.Equal(data[12:], h.sign(data[:12])) {
+		return ErrInvalidClientId
+	}
+	return nil
+}
+
+func (h *hmacClientId) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+//128位随机clientId
+type randomClientId struct{}
+
+func NewRandomClientIdGenerator() IClientIdGenerator {
+	return randomClientId{}
+}
+
+func (randomClientId) Generate(c IConnection) string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (randomClientId) Validate(clientId string) error {
+	if len(clientId) != 32 {
+		return ErrInvalidClientId
+	}
+	if _, err := hex.DecodeString(clientId); err != nil {
+		return ErrInvalidClientId
+	}
+	return nil
+}
+
+const (
+	snowflakeEpoch    = 1577836800000 //2020-01-01 00:00:00 UTC，毫秒
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+//雪花算法clientId，41位毫秒时间戳 + 10位节点id + 12位序号，多节点部署时全局唯一
+type snowflakeClientId struct {
+	nodeId    int64
+	lastStamp int64
+	sequence  int64
+	lock      sync.Mutex
+}
+
+func NewSnowflakeClientIdGenerator(nodeId int64) (IClientIdGenerator, error) {
+	if nodeId < 0 || nodeId > snowflakeMaxNode {
+		return nil, errors.New("snowflake node id must be between 0 and " + strconv.Itoa(snowflakeMaxNode))
+	}
+	return &snowflakeClientId{nodeId: nodeId}, nil
+}
+
+func (s *snowflakeClientId) Generate(c IConnection) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now <= s.lastStamp {
+		//同一毫秒或时钟回拨时沿用上次时间戳递增序号
+		now = s.lastStamp
+		s.sequence = (s.sequence + 1) & snowflakeMaxSeq
+		if s.sequence == 0 {
+			now++
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastStamp = now
+
+	id := now<<(snowflakeNodeBits+snowflakeSeqBits) | s.nodeId<<snowflakeSeqBits | s.sequence
+	return strconv.FormatInt(id, 10)
+}
+
+func (s *snowflakeClientId) Validate(clientId string) error {
+	if _, err := strconv.ParseInt(clientId, 10, 64); err != nil {
+		return ErrInvalidClientId
+	}
+	return nil
+}
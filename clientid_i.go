@@ -0,0 +1,9 @@
+package znets
+
+//clientId生成器
+type IClientIdGenerator interface {
+	//为连接生成clientId，连接建立时调用一次
+	Generate(IConnection) string
+	//校验clientId格式及签名，不合法返回错误
+	Validate(string) error
+}
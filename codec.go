@@ -0,0 +1,77 @@
+package znets
+
+//IPack适配为ICodec，兼容原有的字符串协议解析
+type packCodec struct {
+	pack IPack
+}
+
+func NewPackCodec(pack IPack) ICodec {
+	if codec, ok := pack.(ICodec); ok {
+		return codec
+	}
+	return &packCodec{pack: pack}
+}
+
+func (p *packCodec) Decode(buf []byte) (IMessage, int, error) {
+	length := p.pack.Input(string(buf))
+	if length < 0 {
+		return nil, 0, ErrInvalidFrame
+	}
+	if length == 0 {
+		return nil, 0, nil
+	}
+	if length > len(buf) {
+		return nil, 0, ErrInvalidFrame
+	}
+
+	frame := make([]byte, length)
+	copy(frame, buf[:length])
+	if mp, ok := p.pack.(IMessagePack); ok {
+		msg, err := mp.UnPackMessage(frame)
+		return msg, length, err
+	}
+	return &Message{
+		Data:   p.pack.UnPack(frame),
+		Length: uint32(length),
+	}, length, nil
+}
+
+func (p *packCodec) SupportSeq() bool {
+	return supportSeq(p.pack)
+}
+
+func (p *packCodec) Encode(msg IMessage, dst []byte) ([]byte, error) {
+	if mp, ok := p.pack.(IMessagePack); ok {
+		return append(dst, mp.PackMessage(msg)...), nil
+	}
+	return append(dst, p.pack.Pack(msg.GetData())...), nil
+}
+
+//不做协议解析，每次读取到的数据作为一条消息
+type rawCodec struct{}
+
+func NewRawCodec() ICodec {
+	return rawCodec{}
+}
+
+func (rawCodec) Decode(buf []byte) (IMessage, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	return &Message{
+		Data:   data,
+		Length: uint32(len(data)),
+	}, len(buf), nil
+}
+
+func (rawCodec) Encode(msg IMessage, dst []byte) ([]byte, error) {
+	return append(dst, msg.GetData()...), nil
+}
+
+//协议能否携带seq
+func supportSeq(codec interface{}) bool {
+	sc, ok := codec.(ISeqCodec)
+	return ok && sc.SupportSeq()
+}
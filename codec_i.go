@@ -0,0 +1,15 @@
+package znets
+
+//基于字节的编解码接口
+type ICodec interface {
+	//解码buf中的一帧，返回消息及消耗的字节数，数据不足时返回consumed为0
+	//buf会被复用，返回的消息不能引用buf
+	Decode(buf []byte) (msg IMessage, consumed int, err error)
+	//编码消息，结果追加到dst后返回
+	Encode(msg IMessage, dst []byte) ([]byte, error)
+}
+
+//可选实现，协议能否携带seq，Call需要协议支持seq
+type ISeqCodec interface {
+	SupportSeq() bool
+}
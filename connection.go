@@ -2,28 +2,42 @@ package znets
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 	"io/ioutil"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type HandleFunc func(*net.TCPConn, []byte, int) error
+type HandleFunc func(net.Conn, []byte, int) error
+
+const readBufferSize = 65535
 
 type Connection struct {
 	//连接的套接字
-	Conn *net.TCPConn
+	Conn net.Conn
+	//读写数据的连接，启用TLS或WebSocket时为对应的连接
+	rw net.Conn
+	//TLS连接，未启用TLS时为nil
+	tlsConn *tls.Conn
 	//连接的ID
 	ConnID uint32
+	//客户端连接标识id
+	clientId string
+	//接入的监听名称
+	listener string
 	//连接状态
 	isClosed bool
 	//写通道退出状态的channel
 	ExitChan chan bool
 	//当前连接的处理方法
 	Handles IHandler
-	//无缓冲的读写channel
+	//有界发送队列
 	dataChan chan []byte
 	//父server
 	server IServer
@@ -31,46 +45,88 @@ type Connection struct {
 	property map[string]interface{}
 	//保护锁
 	propertyLock sync.RWMutex
+	//连接关闭或server停止时取消，请求的context由此派生
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	seq       uint32                   //Call分配的序号
+	calls     map[uint32]chan IMessage //等待响应的Call
+	callsLock sync.Mutex
+
+	codec   ICodec          //协议编解码
+	charset ICharset        //字符集转换，nil时不做转换
+	connWg  *sync.WaitGroup //进程中协程连接同步等待，用于在需要结束进程时等待处理未完成连接
+
+	closeLock        sync.Mutex    //关闭锁
+	lastReadTime     int64         //最后读取数据时间，UnixNano
+	lastWriteTime    int64         //最后写入数据时间，UnixNano
+	heartbeatMiss    int32         //连续读空闲次数
+	heartbeat        *Heartbeat    //内置心跳
+	sendPolicy       string        //发送队列满时的处理策略
+	sendTimeout      time.Duration //阻塞策略下的发送超时时间
+	readIdleTimeout  time.Duration
+	writeIdleTimeout time.Duration
+	allIdleTimeout   time.Duration
+}
+
+//创建连接，conn可以是TCP、Unix socket或UDP虚拟连接
+func NewConnection(server IServer, conn net.Conn, id uint32, handler IHandler, wg *sync.WaitGroup) IConnection {
+	return newConnection(server, conn, conn, nil, id, handler, wg)
+}
 
-	packProto IPack           //协议解析
-	connWg    *sync.WaitGroup //进程中协程连接同步等待，用于在需要结束进程时等待处理未完成连接
+//创建TLS连接，tlsConn需已完成握手
+func NewTLSConnection(server IServer, conn net.Conn, tlsConn *tls.Conn, id uint32, handler IHandler, wg *sync.WaitGroup) IConnection {
+	return newConnection(server, conn, tlsConn, tlsConn, id, handler, wg)
 }
 
-func NewConnection(server IServer, conn *net.TCPConn, id uint32, handler IHandler, wg *sync.WaitGroup) IConnection {
+func newConnection(server IServer, conn net.Conn, rw net.Conn, tlsConn *tls.Conn, id uint32, handler IHandler, wg *sync.WaitGroup) *Connection {
 	c := &Connection{
 		Conn:     conn,
+		rw:       rw,
+		tlsConn:  tlsConn,
 		ConnID:   id,
 		isClosed: false,
 		ExitChan: make(chan bool, 1),
 		Handles:  handler,
-		dataChan: make(chan []byte),
+		dataChan: make(chan []byte, DEFAULT_SEND_QUEUE_SIZE),
 		server:   server,
 		property: make(map[string]interface{}),
+		calls:    make(map[uint32]chan IMessage),
+		codec:    rawCodec{},
+
+		listener: DEFAULT_LISTENER,
+		connWg:   wg,
 
-		connWg: wg,
+		lastReadTime:  time.Now().UnixNano(),
+		lastWriteTime: time.Now().UnixNano(),
+		sendPolicy:    SendBlock,
+		sendTimeout:   DEFAULT_WRITE_TIMEOUT,
 	}
 
-	c.server.GetManager().Add(c)
+	c.ctx, c.cancel = context.WithCancel(server.Context())
+	c.clientId = server.GetClientIdGenerator().Generate(c)
 	return c
 }
 
-func (c *Connection) SetPackProto(proto IPack) {
-	c.packProto = proto
-}
-
 //主动踢掉连接
 func (c *Connection) closeConn() {
-	err := c.Conn.SetLinger(-1)
-	if err != nil {
-		Log.Error("Error when setting linger:%s", err.Error())
-		return
+	conn := c.Conn
+	if pc, ok := conn.(*proxyConn); ok {
+		conn = pc.NetConn()
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		err := tc.SetLinger(-1)
+		if err != nil {
+			Log.Error("Error when setting linger:%s", err.Error())
+			return
+		}
 	}
 
 	//<-time.After(2 * time.Second)
 	c.Stop()
 }
 
-//发送数据处理
+//发送数据处理，队列中已有的多帧数据合并写入
 func (c *Connection) StartWriter() {
 	for {
 		select {
@@ -80,9 +136,18 @@ func (c *Connection) StartWriter() {
 				c.closeConn()
 				return
 			}
-			//读写channel有数据时
-			if _, err := c.Conn.Write(data); err != nil {
+			buffers, closing := c.collectBatch(net.Buffers{data})
+
+			c.rw.SetWriteDeadline(time.Now().Add(DEFAULT_WRITE_TIMEOUT))
+			if _, err := buffers.WriteTo(c.rw); err != nil {
 				Log.Error("Send data err:%s", err.Error())
+				c.Stop()
+				return
+			}
+			atomic.StoreInt64(&c.lastWriteTime, time.Now().UnixNano())
+
+			if closing {
+				c.closeConn()
 				return
 			}
 
@@ -96,106 +161,130 @@ func (c *Connection) StartWriter() {
 func (c *Connection) StartReader() {
 	defer c.Stop()
 
-	var recvBuff string
-	var currentPackageLength int
-
+	//可复用的读缓冲，未解析完的数据保留在缓冲头部
+	buff := make([]byte, 0, readBufferSize)
+	mr, isMessage := c.rw.(messageReader)
 	for {
-		var buff [65535]byte
-		n, err := c.Conn.Read(buff[:])
+		var err error
+		if isMessage {
+			buff, err = c.readMessage(mr, buff)
+		} else {
+			buff, err = c.readStream(buff)
+		}
 		if err != nil {
 			Log.Error("read msg data err:%s", err.Error())
 			break
 		}
-		gBbuff, _ := GbToUtf8(buff[:n]) //通讯中有中文简单处理
-		recvBuff += string(gBbuff)
-		recvBuffLen := len(recvBuff)
-
-		if c.packProto != nil {
-			for recvBuff != "" {
-				var message string
-				currentPackageLength = c.packProto.Input(recvBuff) //解析数据输入分割
-				if currentPackageLength > 0 {
-					if currentPackageLength == recvBuffLen {
-						message = recvBuff
-						recvBuff = ""
-						recvBuffLen = 0
-					} else {
-						message = recvBuff[:currentPackageLength]
-						recvBuff = recvBuff[currentPackageLength:]
-						recvBuffLen -= currentPackageLength
-					}
-
-					message = string(c.packProto.UnPack([]byte(message)))
-					msg := &Message{
-						Data:   []byte(message),
-						Length: uint32(currentPackageLength),
-					}
-					currentPackageLength = 0
-
-					//调用通知处理
-					rid := c.server.GetRid()
-					clientId := AddressToClientId(c)
-					req := NewRequest(c, msg, rid, clientId)
-					*(rid)++
-					c.Handles.SendToTasks(req)
-				} else {
-					break
-				}
+		atomic.StoreInt64(&c.lastReadTime, time.Now().UnixNano())
+		atomic.StoreInt32(&c.heartbeatMiss, 0)
+
+		start := 0
+		for start < len(buff) {
+			msg, consumed, err := c.codec.Decode(buff[start:])
+			if err != nil {
+				Log.Error("decode data err:%s, ConnID = %d", err.Error(), c.ConnID)
+				return
 			}
-			continue
+			if consumed == 0 {
+				break
+			}
+			start += consumed
+			c.dispatch(msg)
 		}
-
-		if recvBuff == "" {
+		//按消息读取时每条消息独立解析，丢弃不完整的数据
+		if isMessage {
+			buff = buff[:0]
 			continue
 		}
+		buff = buff[:copy(buff, buff[start:])]
+	}
+}
 
-		msg := &Message{
-			Data:   []byte(recvBuff),
-			Length: uint32(len(recvBuff)),
+//流式读取，数据追加到缓冲
+func (c *Connection) readStream(buff []byte) ([]byte, error) {
+	if len(buff) == cap(buff) {
+		newBuff := make([]byte, len(buff), 2*cap(buff))
+		copy(newBuff, buff)
+		buff = newBuff
+	}
+	n, err := c.rw.Read(buff[len(buff):cap(buff)])
+	return buff[:len(buff)+n], err
+}
+
+//按消息读取，一次读取一条完整的消息
+func (c *Connection) readMessage(mr messageReader, buff []byte) ([]byte, error) {
+	data, err := mr.ReadMessage()
+	return append(buff, data...), err
+}
+
+//将解析出的消息投递到工作池
+func (c *Connection) dispatch(msg IMessage) {
+	if c.charset != nil {
+		data, err := c.charset.Decode(msg.GetData())
+		if err != nil {
+			Log.Error("charset decode err:%s", err.Error())
+		} else {
+			msg.SetData(data)
 		}
-		recvBuff = ""
-		recvBuffLen = 0
-
-		//调用通知处理
-		rid := c.server.GetRid()
-		clientId := AddressToClientId(c)
-		req := NewRequest(c, msg, rid, clientId)
-		*(rid)++
-		c.Handles.SendToTasks(req)
 	}
+	if c.handleHeartbeat(msg) {
+		return
+	}
+	if c.resolveCall(msg) {
+		return
+	}
+
+	//调用通知处理
+	req := NewRequest(c, msg, c.server.NextRid(), c.clientId)
+	c.Handles.SendToTasks(req)
 }
 
 //启动连接
 func (c *Connection) Start() {
-	Log.Info("connection coming in, ConnID = %d, Addr = %s", c.ConnID, c.GetConn().RemoteAddr().String())
+	Log.Info("connection coming in, ConnID = %d, Addr = %s", c.ConnID, c.RemoteAddr().String())
+	//设置完协议、字符集及发送队列后才加入管理，之前广播到该连接的数据不会丢失
+	c.server.GetManager().Add(c)
 	//启动读数据业务
 	go c.StartReader()
 	// 启动写数据业务
 	go c.StartWriter()
+	//启动空闲检测
+	if c.readIdleTimeout > 0 || c.writeIdleTimeout > 0 || c.allIdleTimeout > 0 {
+		go c.startIdleCheck()
+	}
 
 	go c.server.runOnStart(c)
 }
 
 //关闭连接
 func (c *Connection) Stop() {
-	Log.Info("connection close, ConnID = %d, Addr = %s", c.ConnID, c.GetConn().RemoteAddr().String())
-
-	if c.isClosed == true {
+	c.closeLock.Lock()
+	if c.isClosed {
+		c.closeLock.Unlock()
 		return
 	}
 	c.isClosed = true
+	c.closeLock.Unlock()
+
+	Log.Info("connection close, ConnID = %d, Addr = %s", c.ConnID, c.RemoteAddr().String())
+	c.cancel()
 	c.server.runOnStop(c)
-	c.Conn.Close()
+	c.rw.Close()
+	c.failCalls()
 	c.ExitChan <- true
 	close(c.ExitChan)
-	close(c.dataChan)
 
 	c.connWg.Done() //连接wg -1
 	c.server.GetManager().Del(c)
 }
 
-//获取当前连接绑定的conn
-func (c *Connection) GetConn() *net.TCPConn {
+//获取连接的context，连接关闭或server停止时取消
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+//获取当前连接绑定的底层conn
+func (c *Connection) GetConn() net.Conn {
 	return c.Conn
 }
 
@@ -204,23 +293,104 @@ func (c *Connection) GetID() uint32 {
 	return c.ConnID
 }
 
+//获取客户端连接标识id
+func (c *Connection) GetClientId() string {
+	return c.clientId
+}
+
+//获取接入的监听名称
+func (c *Connection) GetListener() string {
+	return c.listener
+}
+
+//获取TLS连接状态，包含客户端证书信息，未启用TLS时返回false
+func (c *Connection) GetTLSState() (tls.ConnectionState, bool) {
+	if c.tlsConn == nil {
+		return tls.ConnectionState{}, false
+	}
+	return c.tlsConn.ConnectionState(), true
+}
+
 //获取远程客户端信息
 func (c *Connection) RemoteAddr() net.Addr {
-	return c.Conn.RemoteAddr()
+	return c.rw.RemoteAddr()
 }
 
-//发送数据
+//发送数据，data为nil时关闭连接
 func (c *Connection) Send(data []byte) error {
-	if c.isClosed {
-		return errors.New("Connection closes")
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+	if data == nil {
+		return c.enqueue(nil)
 	}
 
-	if c.packProto != nil {
-		data = c.packProto.Pack(data)
+	return c.SendMessage(&Message{
+		Data:   data,
+		Length: uint32(len(data)),
+	})
+}
+
+//发送消息，经过协议编码后写入
+func (c *Connection) SendMessage(msg IMessage) error {
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+
+	data, err := c.encode(msg)
+	if err != nil {
+		return err
 	}
-	data, _ = Utf8ToGb(data) //处理中文
-	c.dataChan <- data
-	return nil
+	return c.enqueue(data)
+}
+
+//不阻塞发送，block策略下队列满时返回ErrSendQueueFull，用于广播及工作池满时的busy回复
+func (c *Connection) trySendMessage(msg IMessage) error {
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+
+	frame, err := c.encode(msg)
+	if err != nil {
+		return err
+	}
+	if c.sendPolicy != SendBlock {
+		return c.enqueue(frame)
+	}
+	select {
+	case c.dataChan <- frame:
+		return nil
+	case <-c.ExitChan:
+		return ErrConnectionClosed
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+//字符集转换及协议编码
+func (c *Connection) encode(msg IMessage) ([]byte, error) {
+	payload := msg.GetData()
+	if c.charset != nil {
+		encoded, err := c.charset.Encode(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = encoded
+	}
+	return c.codec.Encode(&Message{
+		Id:     msg.GetId(),
+		Seq:    msg.GetSeq(),
+		Length: uint32(len(payload)),
+		Data:   payload,
+	}, nil)
+}
+
+//连接是否已关闭
+func (c *Connection) closed() bool {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+
+	return c.isClosed
 }
 
 //获取主sever
@@ -252,7 +422,36 @@ func (c *Connection) DelProperty(key string) {
 }
 
 func (c *Connection) SetProtoPack(proto IPack) {
-	c.packProto = proto
+	if proto == nil {
+		c.SetCodec(nil)
+		return
+	}
+	c.SetCodec(NewPackCodec(proto))
+}
+
+//设置编解码，nil表示不做协议解析
+func (c *Connection) SetCodec(codec ICodec) {
+	if codec == nil {
+		codec = rawCodec{}
+	}
+	c.codec = codec
+}
+
+//设置字符集转换，nil表示原始字节透传
+func (c *Connection) SetCharset(charset ICharset) {
+	c.charset = charset
+}
+
+//设置空闲时间，0表示不检测，需在Start之前设置
+func (c *Connection) SetIdleTimeout(read, write, all time.Duration) {
+	c.readIdleTimeout = read
+	c.writeIdleTimeout = write
+	c.allIdleTimeout = all
+}
+
+//设置内置心跳
+func (c *Connection) SetHeartbeat(heartbeat *Heartbeat) {
+	c.heartbeat = heartbeat
 }
 
 func GbToUtf8(s []byte) ([]byte, error) {
@@ -1,18 +1,36 @@
 package znets
 
-import "net"
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
 
 type IConnection interface {
 	Start()
 	Stop()
-	GetConn() *net.TCPConn
+	GetConn() net.Conn
 	GetID() uint32
+	GetClientId() string
+	GetListener() string //接入的监听名称
+	Context() context.Context
 	RemoteAddr() net.Addr
+	GetTLSState() (tls.ConnectionState, bool)
 	Send(data []byte) error
+	Call(ctx context.Context, msgId uint32, payload []byte) (IMessage, error) //发送请求并等待响应
+	SendMessage(msg IMessage) error
 	SetProperty(key string, val interface{})
 	GetProperty(key string) (interface{}, error)
 	DelProperty(key string)
 
 	SetProtoPack(IPack)
+	SetCodec(ICodec)
+	SetCharset(ICharset)
+	SetIdleTimeout(read, write, all time.Duration)
+	SetHeartbeat(*Heartbeat)
+	SetSendQueue(size int, policy string, timeout time.Duration)
+	QueueLen() int
+	QueueCap() int
 	GetServer() IServer
 }
@@ -0,0 +1,80 @@
+package znets
+
+import "sync"
+
+type Context struct {
+	IRequest
+	handlers []HandlerFunc //调用链
+	index    int           //当前执行到的位置
+	aborted  bool          //是否已终断
+
+	keys     map[string]interface{}
+	keysLock sync.RWMutex
+	errors   []error
+}
+
+func NewContext(request IRequest, handlers []HandlerFunc) IContext {
+	return &Context{
+		IRequest: request,
+		handlers: handlers,
+		index:    -1,
+	}
+}
+
+//将v1.0接收IRequest的处理方法转换为HandlerFunc，返回后继续执行调用链
+//传入的request为IContext，需要终断时断言为IContext后调用Abort
+func WrapRequest(fn RequestHandlerFunc) HandlerFunc {
+	return func(ctx IContext) {
+		fn(ctx)
+	}
+}
+
+//执行调用链中的后续处理方法，未调用Next的处理方法返回后自动执行下一个
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) && !c.aborted {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+//终断调用链
+func (c *Context) Abort() {
+	c.aborted = true
+}
+
+func (c *Context) IsAborted() bool {
+	return c.aborted
+}
+
+//设置请求内的key-value
+func (c *Context) Set(key string, val interface{}) {
+	c.keysLock.Lock()
+	defer c.keysLock.Unlock()
+
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
+	}
+	c.keys[key] = val
+}
+
+//获取请求内的key-value
+func (c *Context) Get(key string) (interface{}, bool) {
+	c.keysLock.RLock()
+	defer c.keysLock.RUnlock()
+
+	val, ok := c.keys[key]
+	return val, ok
+}
+
+//记录处理中的错误
+func (c *Context) Error(err error) {
+	if err == nil {
+		return
+	}
+	c.errors = append(c.errors, err)
+}
+
+func (c *Context) Errors() []error {
+	return c.errors
+}
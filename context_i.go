@@ -0,0 +1,20 @@
+package znets
+
+//请求上下文，中间件、前后置钩子及路由处理方法按洋葱模型组成调用链
+type IContext interface {
+	IRequest
+
+	//执行调用链中的后续处理方法
+	Next()
+	//终断调用链，后续处理方法不再执行
+	Abort()
+	IsAborted() bool
+
+	//请求内的key-value存储
+	Set(key string, val interface{})
+	Get(key string) (interface{}, bool)
+
+	//收集处理过程中的错误
+	Error(err error)
+	Errors() []error
+}
@@ -0,0 +1,42 @@
+package znets
+
+import "sync/atomic"
+
+//工作池分发策略
+const (
+	DispatchAffinity    = "affinity"     //按连接id分配固定工作协程，同一连接的消息按顺序处理
+	DispatchRoundRobin  = "round-robin"  //轮询分配
+	DispatchLeastLoaded = "least-loaded" //分配给待处理请求最少的工作协程
+	DispatchUnordered   = "unordered"    //共享队列，空闲的工作协程获取，不保证顺序
+)
+
+//检查分发策略，为空时默认轮询
+func checkDispatch(dispatch string) (string, bool) {
+	switch dispatch {
+	case DispatchAffinity, DispatchRoundRobin, DispatchLeastLoaded, DispatchUnordered:
+	case "":
+		dispatch = DispatchRoundRobin
+	default:
+		return DispatchRoundRobin, false
+	}
+	return dispatch, true
+}
+
+//按分发策略选择工作协程
+func (h *Handler) selectWork(rq IRequest) uint32 {
+	switch h.dispatch {
+	case DispatchAffinity:
+		return rq.GetConnection().GetID() % h.workpoolSize
+	case DispatchLeastLoaded:
+		id := uint32(0)
+		min := atomic.LoadInt32(&h.loads[0])
+		for i := 1; i < len(h.loads); i++ {
+			if load := atomic.LoadInt32(&h.loads[i]); load < min {
+				id, min = uint32(i), load
+			}
+		}
+		return id
+	default:
+		return (atomic.AddUint32(&h.next, 1) - 1) % h.workpoolSize
+	}
+}
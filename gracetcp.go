@@ -5,6 +5,8 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -18,75 +20,168 @@ const (
 )
 
 func ListenTCP(nett string, laddr *net.TCPAddr, server *Server) (*Listener, error) {
-	// 获取环境变量
-	isGraceful := false
-	if os.Getenv(GRACEFUL_ENVIRON_KEY) != "" {
-		isGraceful = true
+	var ln net.Listener
+	var err error
+
+	// 如果是重启的，就新写一个文件描述符
+	if file := inheritedFile(); file != nil {
+		ln, err = net.FileListener(file)
+	} else {
+		ln, err = net.ListenTCP(nett, laddr)
+	}
+
+	if err != nil {
+		return nil, err
 	}
+	return NewListener(ln, server), nil
+}
 
+//监听unix socket，path以@开头时为abstract socket
+func ListenUnix(path string, mode os.FileMode, server *Server) (*Listener, error) {
 	var ln net.Listener
 	var err error
 
 	// 如果是重启的，就新写一个文件描述符
-	if isGraceful {
-		file := os.NewFile(3, "")
+	if file := inheritedFile(); file != nil {
 		ln, err = net.FileListener(file)
 	} else {
-		ln, err = net.ListenTCP(nett, laddr)
+		isAbstract := strings.HasPrefix(path, "@")
+		if !isAbstract {
+			if err := removeStaleSocket(path); err != nil {
+				return nil, err
+			}
+		}
+		ln, err = net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err == nil && !isAbstract && mode != 0 {
+			err = os.Chmod(path, mode)
+			if err != nil {
+				ln.Close()
+			}
+		}
 	}
 
 	if err != nil {
 		return nil, err
 	}
-	listener := ln.(*net.TCPListener)
-	li := NewListener(listener, server)
+	return NewListener(ln, server), nil
+}
+
+//删除上次未正常退出遗留的socket文件，仍有进程在监听时返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是socket文件", path)
+	}
 
-	go listenSignals(li)
-	return li, nil
+	con, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		con.Close()
+		return fmt.Errorf("%s 正在被其他进程监听", path)
+	}
+	return os.Remove(path)
+}
+
+//支持优雅重启的监听
+type graceListener interface {
+	GetFd() (uintptr, error)
+	Wait()
+}
+
+//已继承的文件描述符数量
+var inheritedFiles uintptr
+
+//优雅重启时按监听顺序获取继承的文件描述符，从3开始，非重启返回nil
+func inheritedFile() *os.File {
+	if os.Getenv(GRACEFUL_ENVIRON_KEY) == "" {
+		return nil
+	}
+	fd := 3 + atomic.AddUintptr(&inheritedFiles, 1) - 1
+	return os.NewFile(fd, "")
 }
 
-func listenSignals(li *Listener) {
-	c := make(chan os.Signal)
-	signal.Notify(c, syscall.SIGTERM, syscall.SIGUSR2)
+func ListenUDP(nett string, laddr *net.UDPAddr, server *Server) (*UDPListener, error) {
+	var conn net.PacketConn
+	var err error
+
+	// 如果是重启的，就新写一个文件描述符
+	if file := inheritedFile(); file != nil {
+		conn, err = net.FilePacketConn(file)
+	} else {
+		conn, err = net.ListenUDP(nett, laddr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPListener(conn.(*net.UDPConn), server), nil
+}
+
+func listenSignals(s *Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGUSR2, syscall.SIGHUP)
 	for {
 		sig := <-c
 		switch sig {
 		case syscall.SIGUSR2:
 			//启动新进程
-			err := startNewProcess(li)
+			err := startNewProcess(s.graceListeners())
 			if err != nil {
 				fmt.Println(err)
 			} else {
 				// 关闭老进程
-				stopOldProcess(li)
+				stopOldProcess(s)
 			}
+		case syscall.SIGHUP:
+			//重新加载TLS证书
+			s.ReloadTLS()
 		case syscall.SIGTERM:
 			// 关闭老进程
 
-			err := os.Remove(li.server.pidFilePath)
+			err := os.Remove(s.pidFilePath)
 			if err != nil {
 				Log.Info("删除pid文件失败：%s", err.Error())
 			}
-			stopOldProcess(li)
+			//停止服务，取消server的context，通知处理中的请求退出
+			s.cancel()
+			stopOldProcess(s)
 		}
 	}
 }
 
-func stopOldProcess(li *Listener) {
+func stopOldProcess(s *Server) {
 	Log.Info("stopOldProcess")
 	//li.Close()
-	li.server.isExit = true
+	atomic.StoreInt32(&s.isExit, 1)
+	//只停止接受新连接，已有连接上的请求继续正常处理，不取消server的context
+	listeners := s.graceListeners()
+	for _, li := range listeners {
+		if l, ok := li.(*Listener); ok {
+			l.keepSocketFile()
+		}
+	}
 
 	// 等待所有连接都处理完
-	li.Wait()
+	for _, li := range listeners {
+		li.Wait()
+	}
 
 	os.Exit(0)
 }
 
-func startNewProcess(li *Listener) error {
-	listenerFd, err := (*li).GetFd()
-	if err != nil {
-		return fmt.Errorf("failed to get socket file descriptor: %v", err)
+func startNewProcess(listeners []graceListener) error {
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, li := range listeners {
+		listenerFd, err := li.GetFd()
+		if err != nil {
+			return fmt.Errorf("failed to get socket file descriptor: %v", err)
+		}
+		files = append(files, listenerFd)
 	}
 	path := os.Args[0]
 
@@ -101,7 +196,7 @@ func startNewProcess(li *Listener) error {
 
 	execSpec := &syscall.ProcAttr{
 		Env:   environList,
-		Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), listenerFd},
+		Files: files,
 	}
 
 	fork, err1 := syscall.ForkExec(path, os.Args, execSpec)
@@ -1,22 +1,60 @@
 package znets
 
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
 type Handler struct {
-	Middlewares  []HandlerFunc   //中间件集合
-	abort        bool            //中间件执行中是否有中断
-	workpoolSize uint32          //工作池
-	tasks        []chan IRequest //收到请求任务通道
+	//64位原子操作的字段放在开头，保证32位平台上8字节对齐
+	inflight       int64  //排队及处理中的请求数
+	completed      uint64 //已处理完成的请求数
+	rejected       uint64 //被拒绝的请求数
+	sharedLatency  int64  //共享队列最近的排队延迟
+	sharedDequeued int64  //共享队列最近的出队时间
+
+	Middlewares         []HandlerFunc            //中间件集合
+	listenerMiddlewares map[string][]HandlerFunc //按监听名称设置的中间件
+	workpoolSize        uint32                   //工作池
+	tasks               []chan *task             //每个工作协程的任务队列
+	shared              chan *task               //共享任务队列，unordered策略及队列满时使用
+	dispatch            string                   //分发策略
+	next                uint32                   //轮询计数
+	loads               []int32                  //每个工作协程待处理的请求数
 
-	before      HandlerFunc //前置操作
-	after       HandlerFunc //后置操作
-	eventHandle IEvent      //操作接收主体
+	queueSize         int           //任务队列长度
+	rejectPolicy      string        //队列满时的处理策略
+	busyReply         []byte        //busy策略回复的数据
+	onReject          RejectHandler //请求被拒绝时的回调
+	maxWorkers        uint32        //最大工作协程数
+	scaleLatency      time.Duration //扩容的排队延迟阈值
+	workerIdleTimeout time.Duration //扩容的工作协程空闲退出时间
+	workers           int32         //当前工作协程数
+	extraId           uint32        //扩容的工作协程id计数
+	started           int32         //工作池已启动，任务队列创建之后原子设置
 
+	before      HandlerFunc    //前置操作
+	after       HandlerFunc    //后置操作
+	eventHandle IEvent         //操作接收主体
+	onPanic     PanicHandler   //处理方法panic时的回调
+	onError     ErrorHandler   //处理方法错误的统一回调
+	timeout     time.Duration  //请求处理的默认超时时间
+	onTimeout   TimeoutHandler //处理超时的回调
+	router      *Router        //按消息id分发的路由
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		Middlewares:  make([]HandlerFunc, 0),
-		abort:        false,
-		workpoolSize: 10,
+		Middlewares:         make([]HandlerFunc, 0),
+		listenerMiddlewares: make(map[string][]HandlerFunc),
+		workpoolSize:        10,
+		dispatch:            DispatchRoundRobin,
+		queueSize:           DEFAULT_TASK_QUEUE_SIZE,
+		rejectPolicy:        RejectBlock,
+		scaleLatency:        DEFAULT_SCALE_LATENCY,
+		workerIdleTimeout:   DEFAULT_WORKER_IDLE_TIMEOUT,
+		router:              NewRouter(),
 	}
 }
 
@@ -26,15 +64,46 @@ func (h *Handler) RunHandler(request IRequest) {
 		Log.Error("You must set IEvent obj")
 		return
 	}
-	//如果有中间件需要执行
-	for k := range h.Middlewares {
-		h.Middlewares[k](request)
-		if h.abort {
-			h.abort = false //有中断执行
-			return
+	chain, timeout := h.handlers(request)
+	reqCtx := request.GetConnection().Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, timeout)
+		defer cancel()
+		timer := time.AfterFunc(timeout, func() {
+			h.handleTimeout(request, timeout)
+		})
+		defer timer.Stop()
+	}
+	request.setContext(reqCtx)
+
+	ctx := NewContext(request, chain)
+	h.safeRun(request, ctx.Next)
+	h.handleErrors(ctx)
+}
+
+//组装调用链：中间件 -> 监听中间件 -> 前置钩子 -> 路由中间件 -> 处理方法 -> 后置钩子，同时返回处理超时时间
+func (h *Handler) handlers(request IRequest) ([]HandlerFunc, time.Duration) {
+	timeout := h.timeout
+	chain := make([]HandlerFunc, 0, len(h.Middlewares)+4)
+	chain = append(chain, h.Middlewares...)
+	chain = append(chain, h.listenerMiddlewares[request.GetConnection().GetListener()]...)
+	if h.before != nil {
+		chain = append(chain, h.before)
+	}
+	if rt := h.router.match(request.GetID()); rt != nil {
+		chain = append(chain, rt.middlewares...)
+		chain = append(chain, rt.handler)
+		if t := rt.getTimeout(); t > 0 {
+			timeout = t
 		}
+	} else {
+		chain = append(chain, h.onMessage) //未匹配到路由的交由IEvent处理
 	}
-	h.start(request)
+	if h.after != nil {
+		chain = append(chain, h.after)
+	}
+	return chain, timeout
 }
 
 //设置前置处理钩子
@@ -54,9 +123,9 @@ func (h *Handler) Use(rf HandlerFunc) {
 	h.Middlewares = append(h.Middlewares, rf)
 }
 
-//终断请求
-func (h *Handler) Abort() {
-	h.abort = true
+//设置指定监听接入连接的中间件，在全局中间件之后执行
+func (h *Handler) UseListener(name string, rfs ...HandlerFunc) {
+	h.listenerMiddlewares[name] = append(h.listenerMiddlewares[name], rfs...)
 }
 
 //设置工作池数量
@@ -64,36 +133,79 @@ func (h *Handler) SetWorkPoolSize(size uint32) {
 	h.workpoolSize = size
 }
 
+//设置工作池分发策略 affinity|round-robin|least-loaded|unordered，需在启动之前设置
+func (h *Handler) SetDispatch(dispatch string) {
+	d, ok := checkDispatch(dispatch)
+	if !ok {
+		Log.Error("unsupported dispatch %s, use %s", dispatch, d)
+	}
+	h.dispatch = d
+}
+
 //启动工作池
 func (h *Handler) RunWorkPool() {
 	if h.eventHandle != nil {
 		h.eventHandle.OnWorkerStart()
 	}
-	h.tasks = make([]chan IRequest, h.workpoolSize)
+	h.tasks = make([]chan *task, h.workpoolSize)
+	h.shared = make(chan *task, h.queueSize)
+	h.loads = make([]int32, h.workpoolSize)
+	atomic.StoreInt32(&h.workers, int32(h.workpoolSize))
+	atomic.StoreInt64(&h.sharedDequeued, time.Now().UnixNano())
 	for i := 0; i < int(h.workpoolSize); i++ {
-		h.tasks[i] = make(chan IRequest)
-		go h.runWork(h.tasks[i])
+		h.tasks[i] = make(chan *task, h.queueSize)
+		go h.runWork(uint32(i))
 	}
-	Log.Info("[WorkPool] %d workpools are Running...", h.workpoolSize)
+	atomic.StoreInt32(&h.started, 1)
+	if h.maxWorkers > h.workpoolSize {
+		if h.dispatch != DispatchAffinity {
+			go h.autoScale()
+		} else {
+			Log.Warning("[WorkPool] work pool scaling does not work with dispatch %s, ignored", DispatchAffinity)
+		}
+	}
+	Log.Info("[WorkPool] %d workpools are Running..., dispatch %s", h.workpoolSize, h.dispatch)
 }
 
 //协程中启动监听请求到来
-func (h *Handler) runWork(tr chan IRequest) {
+func (h *Handler) runWork(id uint32) {
 	for {
 		select {
-		case rq := <-tr:
-			h.RunHandler(rq)
-			rid := rq.getRid()
-			*rid-- //全局请求数-1
+		case t := <-h.tasks[id]:
+			h.runTask(id, t, false)
+			atomic.AddInt32(&h.loads[id], -1)
+		case t := <-h.shared:
+			h.runTask(id, t, true)
 		}
 	}
 }
 
-//轮询获取工作池处理任务
+//按分发策略交由工作池处理任务
 func (h *Handler) SendToTasks(rq IRequest) {
-	id := *(rq.getRid()) % h.workpoolSize
-	rq.SetWorkId(id)
-	h.tasks[id] <- rq
+	atomic.AddInt64(&h.inflight, 1)
+	t := &task{rq: rq, queuedAt: time.Now()}
+	if h.dispatch == DispatchUnordered {
+		h.enqueue(h.shared, t)
+		return
+	}
+
+	id := h.selectWork(rq)
+	atomic.AddInt32(&h.loads[id], 1)
+	select {
+	case h.tasks[id] <- t:
+		return
+	default:
+	}
+	if h.dispatch == DispatchAffinity {
+		//保证顺序，只能由该工作协程处理
+		if !h.enqueue(h.tasks[id], t) {
+			atomic.AddInt32(&h.loads[id], -1)
+		}
+		return
+	}
+	//工作协程的队列已满，放入共享队列由空闲或扩容的工作协程处理
+	atomic.AddInt32(&h.loads[id], -1)
+	h.enqueue(h.shared, t)
 }
 
 //设置事件处理类
@@ -101,13 +213,22 @@ func (h *Handler) SetEventHandle(event IEvent) {
 	h.eventHandle = event
 }
 
-//开始处理接收信息
-func (h *Handler) start(request IRequest) {
-	if h.before != nil {
-		h.before(request)
-	}
-	h.eventHandle.OnMessage(request)
-	if h.after != nil {
-		h.after(request)
-	}
+//注册消息id路由
+func (h *Handler) Handle(msgId uint32, handler HandlerFunc, middlewares ...HandlerFunc) {
+	h.router.Handle(msgId, handler, middlewares...)
+}
+
+//注册消息id路由并设置处理超时时间
+func (h *Handler) HandleTimeout(msgId uint32, timeout time.Duration, handler HandlerFunc, middlewares ...HandlerFunc) {
+	h.router.HandleTimeout(msgId, timeout, handler, middlewares...)
+}
+
+//注册消息id区间路由组
+func (h *Handler) HandleGroup(min, max uint32, middlewares ...HandlerFunc) *RouteGroup {
+	return h.router.HandleGroup(min, max, middlewares...)
+}
+
+//交由IEvent处理接收信息
+func (h *Handler) onMessage(ctx IContext) {
+	h.eventHandle.OnMessage(ctx)
 }
@@ -1,16 +1,36 @@
 package znets
 
-type HandlerFunc func(request IRequest)
+import "time"
+
+type HandlerFunc func(ctx IContext)
+
+//v1.0的处理方法签名，通过WrapRequest转换为HandlerFunc
+type RequestHandlerFunc func(request IRequest)
+
+//返回错误的处理方法，通过WrapE转换为HandlerFunc
+type HandlerFuncE func(ctx IContext) error
 
 type IHandler interface {
 	RunHandler(request IRequest)
 	Before(HandlerFunc)
 	After(HandlerFunc)
 	Use(HandlerFunc)
-	Abort()
+	UseListener(name string, rfs ...HandlerFunc)
 	RunWorkPool()
 	SendToTasks(rq IRequest)
 	SetWorkPoolSize(size uint32)
+	SetDispatch(dispatch string)
+	SetTaskQueue(size int, policy string, busyReply []byte)
+	SetWorkPoolScale(max uint32, latency time.Duration, idleTimeout time.Duration)
+	OnReject(RejectHandler)
+	OnPanic(PanicHandler)
+	OnError(ErrorHandler)
+	SetHandlerTimeout(time.Duration)
+	OnTimeout(TimeoutHandler)
 
 	SetEventHandle(IEvent)
+
+	Handle(msgId uint32, handler HandlerFunc, middlewares ...HandlerFunc)
+	HandleTimeout(msgId uint32, timeout time.Duration, handler HandlerFunc, middlewares ...HandlerFunc)
+	HandleGroup(min, max uint32, middlewares ...HandlerFunc) *RouteGroup
 }
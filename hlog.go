@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,6 +37,9 @@ const (
 	SUCCESS = "[SUCCESS]"
 )
 
+//Log为nil时使用的日志，避免未创建server时记录日志panic
+var defaultLog = buildLog("")
+
 func NewLog() *HLog {
 	return buildLog("")
 }
@@ -67,7 +71,7 @@ func (l *HLog) fileName() string {
 		file = ""
 		line = 0
 	}
-	if file != "" {
+	if l.workPathLen > 0 && len(file) > l.workPathLen && strings.HasPrefix(file, l.workPath) {
 		file = file[l.workPathLen+1:]
 	}
 	return file + ":" + strconv.Itoa(line)
@@ -94,6 +98,9 @@ func (l *HLog) Success(format string, args ...interface{}) {
 }
 
 func (l *HLog) showLog(prefix, format string, args ...interface{}) {
+	if l == nil {
+		l = defaultLog
+	}
 	fileName := l.fileName()
 	content := fmt.Sprintf(format, args...)
 	currTime := formatTimeByCurrent()
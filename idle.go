@@ -0,0 +1,154 @@
+package znets
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+type IdleState int
+
+const (
+	IdleRead  IdleState = iota + 1 //读空闲
+	IdleWrite                      //写空闲
+	IdleAll                        //读写空闲
+)
+
+func (s IdleState) String() string {
+	switch s {
+	case IdleRead:
+		return "read idle"
+	case IdleWrite:
+		return "write idle"
+	case IdleAll:
+		return "all idle"
+	}
+	return "unknown"
+}
+
+//可选实现，IEvent同时实现该接口时连接空闲会回调OnIdle
+type IIdleEvent interface {
+	OnIdle(IConnection, string, IdleState) //连接对象, clientId, 空闲类型
+}
+
+//内置心跳，ping/pong消息经过协议编解码收发，消息id与消息体同时匹配才视为心跳
+type Heartbeat struct {
+	PingId   uint32 //ping消息id
+	PingData []byte //ping消息体
+	PongId   uint32 //pong消息id
+	PongData []byte //pong消息体
+	MaxMiss  int    //连续读空闲未收到数据的最大次数，超过则断开连接，0表示不断开
+}
+
+func (h *Heartbeat) isPing(msg IMessage) bool {
+	return msg.GetId() == h.PingId && bytes.Equal(msg.GetData(), h.PingData)
+}
+
+func (h *Heartbeat) isPong(msg IMessage) bool {
+	return msg.GetId() == h.PongId && bytes.Equal(msg.GetData(), h.PongData)
+}
+
+func (h *Heartbeat) ping() IMessage {
+	return &Message{Id: h.PingId, Data: h.PingData, Length: uint32(len(h.PingData))}
+}
+
+func (h *Heartbeat) pong() IMessage {
+	return &Message{Id: h.PongId, Data: h.PongData, Length: uint32(len(h.PongData))}
+}
+
+//空闲检测，连接关闭时退出
+func (c *Connection) startIdleCheck() {
+	interval := minDuration(c.readIdleTimeout, c.writeIdleTimeout, c.allIdleTimeout) / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	//上次触发空闲的时间，同一空闲周期内只触发一次
+	now := time.Now().UnixNano()
+	readMark, writeMark, allMark := now, now, now
+	for {
+		select {
+		case <-c.ExitChan:
+			return
+		case t := <-ticker.C:
+			now = t.UnixNano()
+			lastRead := atomic.LoadInt64(&c.lastReadTime)
+			lastWrite := atomic.LoadInt64(&c.lastWriteTime)
+
+			if c.readIdleTimeout > 0 && now-maxInt64(lastRead, readMark) >= int64(c.readIdleTimeout) {
+				readMark = now
+				if !c.onIdle(IdleRead) {
+					return
+				}
+			}
+			if c.writeIdleTimeout > 0 && now-maxInt64(lastWrite, writeMark) >= int64(c.writeIdleTimeout) {
+				writeMark = now
+				if !c.onIdle(IdleWrite) {
+					return
+				}
+			}
+			if c.allIdleTimeout > 0 && now-maxInt64(lastRead, lastWrite, allMark) >= int64(c.allIdleTimeout) {
+				allMark = now
+				if !c.onIdle(IdleAll) {
+					return
+				}
+			}
+		}
+	}
+}
+
+//处理空闲，返回false表示连接已断开
+func (c *Connection) onIdle(state IdleState) bool {
+	c.server.runOnIdle(c, state)
+	if c.heartbeat == nil {
+		return true
+	}
+
+	switch state {
+	case IdleRead:
+		miss := atomic.AddInt32(&c.heartbeatMiss, 1)
+		if c.heartbeat.MaxMiss > 0 && int(miss) > c.heartbeat.MaxMiss {
+			Log.Info("heartbeat timeout, ConnID = %d, miss = %d", c.ConnID, miss)
+			c.Stop()
+			return false
+		}
+		c.SendMessage(c.heartbeat.ping())
+	case IdleWrite:
+		c.SendMessage(c.heartbeat.ping())
+	}
+	return true
+}
+
+//处理心跳消息，返回true表示已处理不需要再分发
+func (c *Connection) handleHeartbeat(msg IMessage) bool {
+	if c.heartbeat == nil {
+		return false
+	}
+	if c.heartbeat.isPing(msg) {
+		c.SendMessage(c.heartbeat.pong())
+		return true
+	}
+	return c.heartbeat.isPong(msg)
+}
+
+func minDuration(ds ...time.Duration) time.Duration {
+	var min time.Duration
+	for _, d := range ds {
+		if d > 0 && (min == 0 || d < min) {
+			min = d
+		}
+	}
+	return min
+}
+
+func maxInt64(vs ...int64) int64 {
+	max := vs[0]
+	for _, v := range vs[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
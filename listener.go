@@ -2,16 +2,17 @@ package znets
 
 import (
 	"net"
+	"os"
 	"sync"
 	"time"
 )
 
-func NewListener(listener *net.TCPListener, server *Server) *Listener {
+func NewListener(listener net.Listener, server *Server) *Listener {
 	return &Listener{listener, &sync.WaitGroup{}, server}
 }
 
 type Listener struct {
-	*net.TCPListener
+	net.Listener
 	wg     *sync.WaitGroup
 	server *Server
 }
@@ -20,17 +21,19 @@ func (l *Listener) GetWg() *sync.WaitGroup {
 	return l.wg
 }
 
-func (l *Listener) Accept() (*net.TCPConn, error) {
-	tc, err := l.AcceptTCP()
+func (l *Listener) Accept() (net.Conn, error) {
+	con, err := l.Listener.Accept()
 	if err != nil {
 		return nil, err
 	}
 
-	tc.SetKeepAlive(true)
-	tc.SetKeepAlivePeriod(3 * time.Minute)
+	if tc, ok := con.(*net.TCPConn); ok {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(3 * time.Minute)
+	}
 
 	l.wg.Add(1)
-	return tc, nil
+	return con, nil
 }
 
 func (l *Listener) Wait() {
@@ -38,9 +41,20 @@ func (l *Listener) Wait() {
 }
 
 func (l *Listener) GetFd() (uintptr, error) {
-	file, err := l.TCPListener.File()
+	fl, ok := l.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return 0, os.ErrInvalid
+	}
+	file, err := fl.File()
 	if err != nil {
 		return 0, err
 	}
 	return file.Fd(), nil
 }
+
+//优雅重启时新进程继续使用socket文件，关闭监听时不删除
+func (l *Listener) keepSocketFile() {
+	if ul, ok := l.Listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
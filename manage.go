@@ -5,14 +5,26 @@ import (
 	"sync"
 )
 
+const broadcastBatchSize = 256 //广播时每个协程负责发送的连接数
+
 type Manager struct {
 	connections map[uint32]IConnection
+	clientIds   map[string]IConnection            //clientId => 连接
+	groups      map[string]map[uint32]IConnection //分组 => 组内连接
+	connGroups  map[uint32]map[string]struct{}    //连接 => 加入的分组
+	uids        map[string]map[uint32]IConnection //uid => 绑定的连接
+	connUids    map[uint32]string                 //连接 => 绑定的uid
 	lock        sync.RWMutex
 }
 
 func NewManager() IManager {
 	return &Manager{
 		connections: make(map[uint32]IConnection),
+		clientIds:   make(map[string]IConnection),
+		groups:      make(map[string]map[uint32]IConnection),
+		connGroups:  make(map[uint32]map[string]struct{}),
+		uids:        make(map[string]map[uint32]IConnection),
+		connUids:    make(map[uint32]string),
 	}
 }
 
@@ -22,14 +34,20 @@ func (m *Manager) Add(con IConnection) {
 	defer m.lock.Unlock()
 
 	m.connections[con.GetID()] = con
+	m.clientIds[con.GetClientId()] = con
 }
 
-//删除连接
+//删除连接，同时退出所有分组并解除绑定的uid
 func (m *Manager) Del(con IConnection) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	delete(m.connections, con.GetID())
+	delete(m.clientIds, con.GetClientId())
+	m.leaveAllGroups(con.GetID())
+	if uid, ok := m.connUids[con.GetID()]; ok {
+		m.unbindUid(con.GetID(), uid)
+	}
 }
 
 //获取连接
@@ -43,18 +61,306 @@ func (m *Manager) Get(id uint32) (IConnection, error) {
 	return nil, errors.New("connection not found")
 }
 
+//通过clientId获取连接
+func (m *Manager) GetByClientId(clientId string) (IConnection, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if con, ok := m.clientIds[clientId]; ok {
+		return con, nil
+	}
+	return nil, errors.New("connection not found")
+}
+
 //获取连接数量
 func (m *Manager) Num() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	return len(m.connections)
 }
 
-//关闭所有连接
+//遍历连接，fn返回false时停止遍历，遍历的是连接快照，fn中可以调用Manager的其他方法
+func (m *Manager) Range(fn func(IConnection) bool) {
+	for _, con := range m.snapshot(nil) {
+		if !fn(con) {
+			return
+		}
+	}
+}
+
+//给所有连接发送消息，filter为nil时不过滤，exclude为排除的连接id，返回发送失败的连接及错误
+func (m *Manager) Broadcast(data []byte, filter func(IConnection) bool, exclude ...uint32) map[uint32]error {
+	excludes := make(map[uint32]struct{}, len(exclude))
+	for _, id := range exclude {
+		excludes[id] = struct{}{}
+	}
+	cons := m.snapshot(func(con IConnection) bool {
+		if _, ok := excludes[con.GetID()]; ok {
+			return false
+		}
+		return filter == nil || filter(con)
+	})
+	return sendToConnections(cons, data)
+}
+
+//给指定的多个连接发送消息，返回发送失败的连接及错误
+func (m *Manager) SendToMany(ids []uint32, data []byte) map[uint32]error {
+	errs := make(map[uint32]error)
+	cons := make([]IConnection, 0, len(ids))
+
+	m.lock.RLock()
+	for _, id := range ids {
+		if con, ok := m.connections[id]; ok {
+			cons = append(cons, con)
+		} else {
+			errs[id] = errors.New("connection not found")
+		}
+	}
+	m.lock.RUnlock()
+
+	for id, err := range sendToConnections(cons, data) {
+		errs[id] = err
+	}
+	return errs
+}
+
+//加锁复制连接快照，释放锁之后再过滤，filter中可以调用Manager的其他方法
+func (m *Manager) snapshot(filter func(IConnection) bool) []IConnection {
+	m.lock.RLock()
+	cons := make([]IConnection, 0, len(m.connections))
+	for _, con := range m.connections {
+		cons = append(cons, con)
+	}
+	m.lock.RUnlock()
+
+	if filter == nil {
+		return cons
+	}
+	filtered := cons[:0]
+	for _, con := range cons {
+		if filter(con) {
+			filtered = append(filtered, con)
+		}
+	}
+	return filtered
+}
+
+//关闭所有连接，Stop中会调用Del，不能在持有锁时关闭连接
 func (m *Manager) Clear() {
+	for _, con := range m.snapshot(nil) {
+		con.Stop()
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	for id, con := range m.connections {
-		con.Stop()
-		delete(m.connections, id)
+	m.connections = make(map[uint32]IConnection)
+	m.clientIds = make(map[string]IConnection)
+	m.groups = make(map[string]map[uint32]IConnection)
+	m.connGroups = make(map[uint32]map[string]struct{})
+	m.uids = make(map[string]map[uint32]IConnection)
+	m.connUids = make(map[uint32]string)
+}
+
+//连接加入分组
+func (m *Manager) JoinGroup(id uint32, group string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	con, ok := m.connections[id]
+	if !ok {
+		return errors.New("connection not found")
+	}
+	if _, ok := m.groups[group]; !ok {
+		m.groups[group] = make(map[uint32]IConnection)
+	}
+	m.groups[group][id] = con
+	if _, ok := m.connGroups[id]; !ok {
+		m.connGroups[id] = make(map[string]struct{})
+	}
+	m.connGroups[id][group] = struct{}{}
+	return nil
+}
+
+//连接退出分组
+func (m *Manager) LeaveGroup(id uint32, group string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.leaveGroup(id, group)
+}
+
+//获取分组内的连接
+func (m *Manager) GroupMembers(group string) []IConnection {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	cons := make([]IConnection, 0, len(m.groups[group]))
+	for _, con := range m.groups[group] {
+		cons = append(cons, con)
+	}
+	return cons
+}
+
+//获取分组内的连接数量
+func (m *Manager) GroupCount(group string) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.groups[group])
+}
+
+//获取连接加入的分组
+func (m *Manager) GetGroups(id uint32) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	groups := make([]string, 0, len(m.connGroups[id]))
+	for group := range m.connGroups[id] {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+//给分组内的连接发送消息，exclude为排除的连接id，返回发送失败的连接及错误
+func (m *Manager) SendToGroup(group string, data []byte, exclude ...uint32) map[uint32]error {
+	excludes := make(map[uint32]struct{}, len(exclude))
+	for _, id := range exclude {
+		excludes[id] = struct{}{}
+	}
+
+	m.lock.RLock()
+	cons := make([]IConnection, 0, len(m.groups[group]))
+	for id, con := range m.groups[group] {
+		if _, ok := excludes[id]; !ok {
+			cons = append(cons, con)
+		}
+	}
+	m.lock.RUnlock()
+
+	return sendToConnections(cons, data)
+}
+
+//连接绑定uid，一个uid可以绑定多个连接，一个连接只能绑定一个uid，重复绑定会解除之前的uid
+func (m *Manager) BindUid(id uint32, uid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	con, ok := m.connections[id]
+	if !ok {
+		return errors.New("connection not found")
+	}
+	if old, ok := m.connUids[id]; ok {
+		m.unbindUid(id, old)
+	}
+	if _, ok := m.uids[uid]; !ok {
+		m.uids[uid] = make(map[uint32]IConnection)
+	}
+	m.uids[uid][id] = con
+	m.connUids[id] = uid
+	return nil
+}
+
+//连接解除绑定uid
+func (m *Manager) UnbindUid(id uint32, uid string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.unbindUid(id, uid)
+}
+
+//获取连接绑定的uid
+func (m *Manager) GetUid(id uint32) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	uid, ok := m.connUids[id]
+	return uid, ok
+}
+
+//获取uid绑定的连接
+func (m *Manager) UidConnections(uid string) []IConnection {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	cons := make([]IConnection, 0, len(m.uids[uid]))
+	for _, con := range m.uids[uid] {
+		cons = append(cons, con)
+	}
+	return cons
+}
+
+//给uid绑定的所有连接发送消息，返回发送失败的连接及错误
+func (m *Manager) SendToUid(uid string, data []byte) map[uint32]error {
+	return sendToConnections(m.UidConnections(uid), data)
+}
+
+func (m *Manager) unbindUid(id uint32, uid string) {
+	if m.connUids[id] != uid {
+		return
+	}
+	delete(m.connUids, id)
+	if cons, ok := m.uids[uid]; ok {
+		delete(cons, id)
+		if len(cons) == 0 {
+			delete(m.uids, uid)
+		}
+	}
+}
+
+func (m *Manager) leaveGroup(id uint32, group string) {
+	if members, ok := m.groups[group]; ok {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(m.groups, group)
+		}
+	}
+	if groups, ok := m.connGroups[id]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(m.connGroups, id)
+		}
+	}
+}
+
+func (m *Manager) leaveAllGroups(id uint32) {
+	for group := range m.connGroups[id] {
+		m.leaveGroup(id, group)
+	}
+}
+
+//分批并发发送，不阻塞等待发送队列，慢连接不影响同批的其他连接，返回发送失败的连接及错误
+func sendToConnections(cons []IConnection, data []byte) map[uint32]error {
+	errs := make(map[uint32]error)
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+
+	for start := 0; start < len(cons); start += broadcastBatchSize {
+		end := start + broadcastBatchSize
+		if end > len(cons) {
+			end = len(cons)
+		}
+		wg.Add(1)
+		go func(batch []IConnection) {
+			defer wg.Done()
+			for _, con := range batch {
+				if err := trySendMessage(con, &Message{Data: data, Length: uint32(len(data))}); err != nil {
+					errLock.Lock()
+					errs[con.GetID()] = err
+					errLock.Unlock()
+				}
+			}
+		}(cons[start:end])
+	}
+	wg.Wait()
+	return errs
+}
+
+//连接支持时不阻塞发送，block策略下队列满返回ErrSendQueueFull
+func trySendMessage(con IConnection, msg IMessage) error {
+	if c, ok := con.(interface{ trySendMessage(IMessage) error }); ok {
+		return c.trySendMessage(msg)
 	}
+	return con.SendMessage(msg)
 }
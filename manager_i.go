@@ -4,6 +4,24 @@ type IManager interface {
 	Add(con IConnection)
 	Del(con IConnection)
 	Get(id uint32) (IConnection, error)
+	GetByClientId(clientId string) (IConnection, error)
 	Num() int
 	Clear()
+
+	Range(fn func(IConnection) bool)
+	Broadcast(data []byte, filter func(IConnection) bool, exclude ...uint32) map[uint32]error
+	SendToMany(ids []uint32, data []byte) map[uint32]error
+
+	JoinGroup(id uint32, group string) error
+	LeaveGroup(id uint32, group string)
+	GroupMembers(group string) []IConnection
+	GroupCount(group string) int
+	GetGroups(id uint32) []string
+	SendToGroup(group string, data []byte, exclude ...uint32) map[uint32]error
+
+	BindUid(id uint32, uid string) error
+	UnbindUid(id uint32, uid string)
+	GetUid(id uint32) (string, bool)
+	UidConnections(uid string) []IConnection
+	SendToUid(uid string, data []byte) map[uint32]error
 }
@@ -2,6 +2,7 @@ package znets
 
 type Message struct {
 	Id     uint32
+	Seq    uint32 //请求响应的序号，0表示没有序号
 	Length uint32
 	Data   []byte
 }
@@ -22,6 +23,14 @@ func (msg *Message) GetId() uint32 {
 	return msg.Id
 }
 
+func (msg *Message) SetSeq(seq uint32) {
+	msg.Seq = seq
+}
+
+func (msg *Message) GetSeq() uint32 {
+	return msg.Seq
+}
+
 func (msg *Message) SetLen(len uint32) {
 	msg.Length = len
 }
@@ -7,6 +7,9 @@ type IMessage interface {
 	SetData([]byte)
 	SetId(uint32)
 
+	SetSeq(uint32) //请求响应的序号，响应消息带SEQ_REPLY标记
+	GetSeq() uint32
+
 	SetLen(uint32)
 	GetLen() uint32
 }
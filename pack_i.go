@@ -1,7 +1,17 @@
 package znets
 
+import "errors"
+
+var ErrInvalidFrame = errors.New("invalid frame")
+
 type IPack interface {
 	Input(string) int
 	Pack([]byte) []byte
 	UnPack([]byte) []byte
 }
+
+//可选实现，解包时直接构建消息，可从头部解析出消息id
+type IMessagePack interface {
+	PackMessage(IMessage) []byte
+	UnPackMessage([]byte) (IMessage, error)
+}
@@ -0,0 +1,262 @@
+package znets
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const DEFAULT_MAX_FRAME_LENGTH = 16 << 20 //默认最大帧长度，防止恶意的长度字段导致读取缓冲无限增长
+
+//基于长度字段的二进制协议解析
+//帧长度 = LengthFieldOffset + LengthFieldLength + 长度字段值 + LengthAdjustment
+type LengthFieldPack struct {
+	ByteOrder           binary.ByteOrder //字节序，默认大端
+	LengthFieldOffset   int              //长度字段偏移
+	LengthFieldLength   int              //长度字段字节数 1|2|4|8
+	LengthAdjustment    int              //长度字段值的修正值
+	InitialBytesToStrip int              //解包时剥离的头部字节数
+	IdFieldOffset       int              //消息id字段偏移
+	IdFieldLength       int              //消息id字段字节数 0|1|2|4，0表示没有id字段
+	SeqFieldOffset      int              //seq字段偏移
+	SeqFieldLength      int              //seq字段字节数 0|2|4，0表示没有seq字段，最高位为响应标记
+	MaxFrameLength      int              //最大帧长度，0使用默认的16M，小于0表示不限制
+}
+
+//创建 [长度][消息体] 格式的协议解析，长度字段值为消息体长度
+func NewLengthFieldPack(lengthFieldLength int) *LengthFieldPack {
+	return &LengthFieldPack{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   lengthFieldLength,
+		InitialBytesToStrip: lengthFieldLength,
+	}
+}
+
+//是否设置了seq字段
+func (p *LengthFieldPack) SupportSeq() bool {
+	return p.SeqFieldLength > 0
+}
+
+//返回完整帧长度，数据不足返回0，非法数据返回-1
+func (p *LengthFieldPack) Input(data string) int {
+	return p.frameLength([]byte(data))
+}
+
+//解码一帧
+func (p *LengthFieldPack) Decode(buf []byte) (IMessage, int, error) {
+	length := p.frameLength(buf)
+	if length < 0 {
+		return nil, 0, ErrInvalidFrame
+	}
+	if length == 0 {
+		return nil, 0, nil
+	}
+
+	frame := make([]byte, length)
+	copy(frame, buf[:length])
+	msg, err := p.UnPackMessage(frame)
+	return msg, length, err
+}
+
+//编码消息，长度为负、超出长度字段范围或最大帧长度时返回ErrInvalidFrame
+func (p *LengthFieldPack) Encode(msg IMessage, dst []byte) ([]byte, error) {
+	if !p.valid() {
+		return dst, ErrInvalidFrame
+	}
+	frame, err := p.packMessage(msg)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, frame...), nil
+}
+
+//打包，消息id为0
+func (p *LengthFieldPack) Pack(data []byte) []byte {
+	return p.PackMessage(&Message{Data: data})
+}
+
+//解包，返回剥离头部后的消息体
+func (p *LengthFieldPack) UnPack(data []byte) []byte {
+	if len(data) < p.InitialBytesToStrip {
+		return nil
+	}
+	return data[p.InitialBytesToStrip:]
+}
+
+//打包消息，消息id写入头部id字段，消息过长或长度字段为负时返回nil
+func (p *LengthFieldPack) PackMessage(msg IMessage) []byte {
+	frame, err := p.packMessage(msg)
+	if err != nil {
+		Log.Error("LengthFieldPack: pack message err:%s, length = %d", err.Error(), len(msg.GetData()))
+		return nil
+	}
+	return frame
+}
+
+func (p *LengthFieldPack) packMessage(msg IMessage) ([]byte, error) {
+	body := msg.GetData()
+	header := make([]byte, p.headerLength())
+	if max := p.maxFrameLength(); max > 0 && len(header)+len(body) > max {
+		return nil, ErrInvalidFrame
+	}
+	lengthEnd := p.LengthFieldOffset + p.LengthFieldLength
+	value := len(header) + len(body) - lengthEnd - p.LengthAdjustment
+	//长度为负或长度字段放不下时写入的长度与帧不一致，对端读到的是错误的数据流
+	if value < 0 {
+		return nil, ErrInvalidFrame
+	}
+	if p.LengthFieldLength < 8 && uint64(value) >= 1<<(8*p.LengthFieldLength) {
+		return nil, ErrInvalidFrame
+	}
+	p.writeUint(header[p.LengthFieldOffset:lengthEnd], p.LengthFieldLength, uint64(value))
+	if p.IdFieldLength > 0 {
+		p.writeUint(header[p.IdFieldOffset:p.idEnd()], p.IdFieldLength, uint64(msg.GetId()))
+	}
+	if p.SeqFieldLength > 0 {
+		seq := uint64(msg.GetSeq() &^ SEQ_REPLY)
+		if msg.GetSeq()&SEQ_REPLY != 0 {
+			seq |= p.seqReplyBit()
+		}
+		p.writeUint(header[p.SeqFieldOffset:p.seqEnd()], p.SeqFieldLength, seq)
+	}
+	return append(header, body...), nil
+}
+
+//解包消息，从头部解析出消息id与长度
+func (p *LengthFieldPack) UnPackMessage(data []byte) (IMessage, error) {
+	if len(data) < p.headerLength() || len(data) < p.InitialBytesToStrip {
+		return nil, ErrInvalidFrame
+	}
+	msg := &Message{
+		Length: uint32(p.readUint(data[p.LengthFieldOffset:p.LengthFieldOffset+p.LengthFieldLength], p.LengthFieldLength)),
+		Data:   data[p.InitialBytesToStrip:],
+	}
+	if p.IdFieldLength > 0 {
+		msg.Id = uint32(p.readUint(data[p.IdFieldOffset:p.idEnd()], p.IdFieldLength))
+	}
+	if p.SeqFieldLength > 0 {
+		seq := p.readUint(data[p.SeqFieldOffset:p.seqEnd()], p.SeqFieldLength)
+		msg.Seq = uint32(seq &^ p.seqReplyBit())
+		if seq&p.seqReplyBit() != 0 {
+			msg.Seq |= SEQ_REPLY
+		}
+	}
+	return msg, nil
+}
+
+func (p *LengthFieldPack) frameLength(data []byte) int {
+	if !p.valid() {
+		Log.Error("LengthFieldPack: invalid field length")
+		return -1
+	}
+
+	lengthEnd := p.LengthFieldOffset + p.LengthFieldLength
+	if len(data) < lengthEnd || len(data) < p.idEnd() || len(data) < p.seqEnd() {
+		return 0
+	}
+
+	value := p.readUint(data[p.LengthFieldOffset:lengthEnd], p.LengthFieldLength)
+	if value > uint64(math.MaxInt32) {
+		return -1
+	}
+	frameLength := lengthEnd + int(value) + p.LengthAdjustment
+	if frameLength < p.headerLength() || frameLength < p.InitialBytesToStrip {
+		return -1
+	}
+	if max := p.maxFrameLength(); max > 0 && frameLength > max {
+		return -1
+	}
+	if len(data) < frameLength {
+		return 0
+	}
+	return frameLength
+}
+
+func (p *LengthFieldPack) valid() bool {
+	return validFieldLength(p.LengthFieldLength, true) && validFieldLength(p.IdFieldLength, false) &&
+		(p.SeqFieldLength == 0 || p.SeqFieldLength == 2 || p.SeqFieldLength == 4)
+}
+
+func (p *LengthFieldPack) idEnd() int {
+	if p.IdFieldLength == 0 {
+		return 0
+	}
+	return p.IdFieldOffset + p.IdFieldLength
+}
+
+func (p *LengthFieldPack) seqEnd() int {
+	if p.SeqFieldLength == 0 {
+		return 0
+	}
+	return p.SeqFieldOffset + p.SeqFieldLength
+}
+
+//seq字段中响应标记所在的最高位
+func (p *LengthFieldPack) seqReplyBit() uint64 {
+	return 1 << (8*p.SeqFieldLength - 1)
+}
+
+//头部长度，取长度、id及seq字段结束位置的最大值
+func (p *LengthFieldPack) headerLength() int {
+	length := p.LengthFieldOffset + p.LengthFieldLength
+	if p.idEnd() > length {
+		length = p.idEnd()
+	}
+	if p.seqEnd() > length {
+		length = p.seqEnd()
+	}
+	return length
+}
+
+//最大帧长度，小于等于0表示不限制
+func (p *LengthFieldPack) maxFrameLength() int {
+	if p.MaxFrameLength == 0 {
+		return DEFAULT_MAX_FRAME_LENGTH
+	}
+	return p.MaxFrameLength
+}
+
+func (p *LengthFieldPack) byteOrder() binary.ByteOrder {
+	if p.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return p.ByteOrder
+}
+
+func (p *LengthFieldPack) readUint(b []byte, size int) uint64 {
+	switch size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(p.byteOrder().Uint16(b))
+	case 4:
+		return uint64(p.byteOrder().Uint32(b))
+	case 8:
+		return p.byteOrder().Uint64(b)
+	}
+	return 0
+}
+
+func (p *LengthFieldPack) writeUint(b []byte, size int, v uint64) {
+	switch size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		p.byteOrder().PutUint16(b, uint16(v))
+	case 4:
+		p.byteOrder().PutUint32(b, uint32(v))
+	case 8:
+		p.byteOrder().PutUint64(b, v)
+	}
+}
+
+func validFieldLength(size int, allowLong bool) bool {
+	switch size {
+	case 1, 2, 4:
+		return true
+	case 8:
+		return allowLong
+	case 0:
+		return !allowLong
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package znets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestLengthFieldPackRoundTrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		pack  *LengthFieldPack
+		msg   *Message
+		frame []byte //期望的编码结果，nil表示不检查
+		data  []byte //解码后的数据，nil表示与msg.Data相同
+	}{
+		{name: "length 1", pack: NewLengthFieldPack(1), msg: &Message{Data: []byte("abc")}, frame: []byte{3, 'a', 'b', 'c'}},
+		{name: "length 2", pack: NewLengthFieldPack(2), msg: &Message{Data: []byte("abc")}, frame: []byte{0, 3, 'a', 'b', 'c'}},
+		{name: "length 4", pack: NewLengthFieldPack(4), msg: &Message{Data: []byte("abc")}, frame: []byte{0, 0, 0, 3, 'a', 'b', 'c'}},
+		{name: "length 8", pack: NewLengthFieldPack(8), msg: &Message{Data: []byte("abc")}, frame: []byte{0, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}},
+		{
+			name:  "little endian 2",
+			pack:  &LengthFieldPack{ByteOrder: binary.LittleEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+			msg:   &Message{Data: []byte("abc")},
+			frame: []byte{3, 0, 'a', 'b', 'c'},
+		},
+		{
+			name:  "little endian 4 with id",
+			pack:  &LengthFieldPack{ByteOrder: binary.LittleEndian, LengthFieldLength: 4, LengthAdjustment: 2, IdFieldOffset: 4, IdFieldLength: 2, InitialBytesToStrip: 6},
+			msg:   &Message{Id: 0x0102, Data: []byte("abc")},
+			frame: []byte{3, 0, 0, 0, 2, 1, 'a', 'b', 'c'},
+		},
+		{
+			name:  "length offset",
+			pack:  &LengthFieldPack{LengthFieldOffset: 2, LengthFieldLength: 4, IdFieldOffset: 0, IdFieldLength: 2, InitialBytesToStrip: 6},
+			msg:   &Message{Id: 7, Data: []byte("abc")},
+			frame: []byte{0, 7, 0, 0, 0, 3, 'a', 'b', 'c'},
+		},
+		{
+			name:  "length includes header",
+			pack:  &LengthFieldPack{LengthFieldLength: 4, LengthAdjustment: -4, InitialBytesToStrip: 4},
+			msg:   &Message{Data: []byte("abc")},
+			frame: []byte{0, 0, 0, 7, 'a', 'b', 'c'},
+		},
+		{
+			name:  "no strip",
+			pack:  &LengthFieldPack{LengthFieldLength: 2},
+			msg:   &Message{Data: []byte("abc")},
+			frame: []byte{0, 3, 'a', 'b', 'c'},
+			data:  []byte{0, 3, 'a', 'b', 'c'},
+		},
+		{
+			name:  "id and seq 4",
+			pack:  &LengthFieldPack{LengthFieldLength: 4, LengthAdjustment: 6, IdFieldOffset: 4, IdFieldLength: 2, SeqFieldOffset: 6, SeqFieldLength: 4, InitialBytesToStrip: 10},
+			msg:   &Message{Id: 1001, Seq: 5 | SEQ_REPLY, Data: []byte("abc")},
+			frame: []byte{0, 0, 0, 3, 0x03, 0xe9, 0x80, 0, 0, 5, 'a', 'b', 'c'},
+		},
+		{
+			name:  "id 1 and seq 2",
+			pack:  &LengthFieldPack{LengthFieldLength: 2, LengthAdjustment: 3, IdFieldOffset: 2, IdFieldLength: 1, SeqFieldOffset: 3, SeqFieldLength: 2, InitialBytesToStrip: 5},
+			msg:   &Message{Id: 9, Seq: MAX_SEQ, Data: []byte("abc")},
+			frame: []byte{0, 3, 9, 0x7f, 0xff, 'a', 'b', 'c'},
+		},
+		{name: "empty body", pack: NewLengthFieldPack(4), msg: &Message{}, frame: []byte{0, 0, 0, 0}, data: []byte{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			frame, err := c.pack.Encode(c.msg, nil)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if c.frame != nil && !bytes.Equal(frame, c.frame) {
+				t.Fatalf("frame %v, want %v", frame, c.frame)
+			}
+
+			//数据不足时不消耗数据
+			for i := 0; i < len(frame); i++ {
+				msg, consumed, err := c.pack.Decode(frame[:i])
+				if err != nil || consumed != 0 || msg != nil {
+					t.Fatalf("decode %d bytes: msg %v consumed %d err %v", i, msg, consumed, err)
+				}
+			}
+
+			//两帧连续时只消耗第一帧
+			buf := append(append([]byte{}, frame...), frame...)
+			msg, consumed, err := c.pack.Decode(buf)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if consumed != len(frame) {
+				t.Fatalf("consumed %d, want %d", consumed, len(frame))
+			}
+			want := c.data
+			if want == nil {
+				want = c.msg.Data
+			}
+			if !bytes.Equal(msg.GetData(), want) {
+				t.Fatalf("data %v, want %v", msg.GetData(), want)
+			}
+			if c.pack.IdFieldLength > 0 && msg.GetId() != c.msg.Id {
+				t.Fatalf("id %d, want %d", msg.GetId(), c.msg.Id)
+			}
+			if c.pack.SeqFieldLength > 0 && msg.GetSeq() != c.msg.Seq {
+				t.Fatalf("seq %#x, want %#x", msg.GetSeq(), c.msg.Seq)
+			}
+			//返回的消息不能引用buf
+			buf[len(buf)-1] ^= 0xff
+			buf[consumed-1] ^= 0xff
+			if !bytes.Equal(msg.GetData(), want) {
+				t.Fatal("decoded message references the input buffer")
+			}
+		})
+	}
+}
+
+func TestLengthFieldPackEncodeLimits(t *testing.T) {
+	cases := []struct {
+		name string
+		pack *LengthFieldPack
+		size int
+		err  bool
+	}{
+		{name: "length 1 max", pack: NewLengthFieldPack(1), size: 255},
+		{name: "length 1 overflow", pack: NewLengthFieldPack(1), size: 256, err: true},
+		{name: "length 2 max", pack: NewLengthFieldPack(2), size: 65535},
+		{name: "length 2 overflow", pack: NewLengthFieldPack(2), size: 65536, err: true},
+		{name: "length 2 adjustment overflow", pack: &LengthFieldPack{LengthFieldLength: 2, LengthAdjustment: -2}, size: 65534, err: true},
+		{name: "max frame", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: 16}, size: 12},
+		{name: "max frame overflow", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: 16}, size: 13, err: true},
+		{name: "default max frame", pack: NewLengthFieldPack(4), size: DEFAULT_MAX_FRAME_LENGTH - 4},
+		{name: "default max frame overflow", pack: NewLengthFieldPack(4), size: DEFAULT_MAX_FRAME_LENGTH - 3, err: true},
+		{name: "unlimited", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: -1}, size: DEFAULT_MAX_FRAME_LENGTH},
+		{name: "negative length", pack: &LengthFieldPack{LengthFieldLength: 4, LengthAdjustment: 8}, size: 4, err: true},
+		{name: "invalid length field", pack: &LengthFieldPack{LengthFieldLength: 3}, size: 1, err: true},
+		{name: "invalid id field", pack: &LengthFieldPack{LengthFieldLength: 4, IdFieldLength: 8}, size: 1, err: true},
+		{name: "invalid seq field", pack: &LengthFieldPack{LengthFieldLength: 4, SeqFieldLength: 1}, size: 1, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dst := []byte("prefix")
+			out, err := c.pack.Encode(&Message{Data: make([]byte, c.size)}, dst)
+			if c.err {
+				if !errors.Is(err, ErrInvalidFrame) {
+					t.Fatalf("err %v, want %v", err, ErrInvalidFrame)
+				}
+				if !bytes.Equal(out, dst) {
+					t.Fatal("dst modified on error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !bytes.HasPrefix(out, dst) {
+				t.Fatal("frame not appended to dst")
+			}
+			if c.pack.valid() {
+				if _, consumed, err := c.pack.Decode(out[len(dst):]); err != nil || consumed != len(out)-len(dst) {
+					t.Fatalf("decode: consumed %d err %v", consumed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldPackDecodeInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		pack *LengthFieldPack
+		buf  []byte
+		err  bool
+	}{
+		{name: "over max frame", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: 16}, buf: []byte{0, 0, 0, 13}, err: true},
+		{name: "at max frame", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: 16}, buf: []byte{0, 0, 0, 12}},
+		{name: "over default max frame", pack: NewLengthFieldPack(4), buf: []byte{0x01, 0, 0, 0x01}, err: true},
+		{name: "unlimited", pack: &LengthFieldPack{LengthFieldLength: 4, MaxFrameLength: -1}, buf: []byte{0x7f, 0, 0, 0}},
+		{name: "length 8 overflow", pack: &LengthFieldPack{LengthFieldLength: 8, MaxFrameLength: -1}, buf: []byte{0, 0, 0, 1, 0, 0, 0, 0}, err: true},
+		{name: "shorter than header", pack: &LengthFieldPack{LengthFieldLength: 4, LengthAdjustment: -4, IdFieldOffset: 4, IdFieldLength: 2}, buf: []byte{0, 0, 0, 2, 0, 1}, err: true},
+		{name: "shorter than strip", pack: &LengthFieldPack{LengthFieldLength: 2, InitialBytesToStrip: 4}, buf: []byte{0, 1, 'a'}, err: true},
+		{name: "invalid length field", pack: &LengthFieldPack{LengthFieldLength: 3}, buf: []byte{0, 0, 1, 'a'}, err: true},
+		{name: "waiting id field", pack: &LengthFieldPack{LengthFieldLength: 2, IdFieldOffset: 2, IdFieldLength: 2}, buf: []byte{0, 0, 0}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg, consumed, err := c.pack.Decode(c.buf)
+			if c.err {
+				if !errors.Is(err, ErrInvalidFrame) {
+					t.Fatalf("err %v, want %v", err, ErrInvalidFrame)
+				}
+				return
+			}
+			if err != nil || consumed != 0 || msg != nil {
+				t.Fatalf("want more data, got msg %v consumed %d err %v", msg, consumed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package znets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_PROXY_HEADER_TIMEOUT = 5 * time.Second
+
+	proxyV1MaxLength = 107
+)
+
+//PROXY协议v2的TLV类型
+const (
+	PP2_TYPE_ALPN      = 0x01
+	PP2_TYPE_AUTHORITY = 0x02
+	PP2_TYPE_CRC32C    = 0x03
+	PP2_TYPE_NOOP      = 0x04
+	PP2_TYPE_UNIQUE_ID = 0x05
+	PP2_TYPE_SSL       = 0x20
+	PP2_TYPE_NETNS     = 0x30
+)
+
+var (
+	ErrProxyHeader     = errors.New("invalid proxy protocol header")
+	ErrProxyRequired   = errors.New("proxy protocol header required")
+	ErrProxyNotTrusted = errors.New("proxy protocol from untrusted source")
+	ErrProxyNoTrusted  = errors.New("proxy protocol requires trusted sources, use 0.0.0.0/0 and ::/0 to trust all")
+
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+//PROXY协议设置，仅支持流式连接(tcp|ws|unix)
+type ProxyProtocolOptions struct {
+	Trusted  []string      //允许发送PROXY协议头的来源CIDR，如 10.0.0.0/8，必须设置，允许所有来源需显式设置 0.0.0.0/0 及 ::/0，unix socket视为可信
+	Timeout  time.Duration //读取协议头的超时时间，默认5s，可信来源的连接在收到首个数据或超时之前不会交给后续处理，Required为false时超时视为没有协议头
+	Required bool          //必须由可信来源发送协议头，否则关闭连接
+}
+
+//PROXY协议v2的TLV扩展信息
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+//解析出的PROXY协议头
+type ProxyHeader struct {
+	Version    int      //协议版本 1|2
+	Local      bool     //v2的LOCAL命令，如负载均衡器的健康检查，地址保持不变
+	SourceAddr net.Addr //真实的客户端地址
+	DestAddr   net.Addr //客户端连接的目标地址
+	TLVs       []ProxyTLV
+}
+
+//获取指定类型的TLV
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+//PROXY协议解析
+type proxyProtocol struct {
+	trusted  []*net.IPNet
+	timeout  time.Duration
+	required bool
+}
+
+func newProxyProtocol(options *ProxyProtocolOptions) (*proxyProtocol, error) {
+	//未设置可信来源时任何直连的客户端都可以伪造地址，不允许
+	if len(options.Trusted) == 0 {
+		return nil, ErrProxyNoTrusted
+	}
+	p := &proxyProtocol{
+		timeout:  options.Timeout,
+		required: options.Required,
+	}
+	if p.timeout <= 0 {
+		p.timeout = DEFAULT_PROXY_HEADER_TIMEOUT
+	}
+	for _, cidr := range options.Trusted {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		p.trusted = append(p.trusted, network)
+	}
+	return p, nil
+}
+
+//来源地址是否可信
+func (p *proxyProtocol) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	for _, network := range p.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//读取协议头，返回使用真实地址的连接，不可信来源不解析协议头
+func (p *proxyProtocol) accept(conn net.Conn) (net.Conn, error) {
+	if !p.isTrusted(conn.RemoteAddr()) {
+		if p.required {
+			return nil, ErrProxyNotTrusted
+		}
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(p.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReaderSize(conn, 256)
+	version, err := peekProxyVersion(br)
+	if err != nil {
+		if p.required {
+			return nil, err
+		}
+		//超时或首个数据不足签名长度，视为没有协议头，已读取的数据保留在缓冲中
+		return &proxyConn{Conn: conn, br: br}, nil
+	}
+	header, err := readProxyVersion(br, version)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil && p.required {
+		return nil, ErrProxyRequired
+	}
+	return &proxyConn{Conn: conn, br: br, header: header}, nil
+}
+
+//读取协议头，没有协议头时返回nil
+func readProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	version, err := peekProxyVersion(br)
+	if err != nil {
+		return nil, err
+	}
+	return readProxyVersion(br, version)
+}
+
+//按签名判断协议头版本，没有协议头时返回0，数据与签名不一致时立即返回，不等待更多数据
+func peekProxyVersion(br *bufio.Reader) (int, error) {
+	for n := 1; ; n++ {
+		buf, err := br.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		v1 := bytes.HasPrefix(proxyV1Signature, buf)
+		v2 := bytes.HasPrefix(proxyV2Signature, buf)
+		switch {
+		case v1 && n == len(proxyV1Signature):
+			return 1, nil
+		case v2 && n == len(proxyV2Signature):
+			return 2, nil
+		case !v1 && !v2:
+			return 0, nil
+		}
+	}
+}
+
+func readProxyVersion(br *bufio.Reader, version int) (*ProxyHeader, error) {
+	switch version {
+	case 1:
+		return readProxyV1(br)
+	case 2:
+		return readProxyV2(br)
+	}
+	return nil, nil
+}
+
+//v1文本格式：PROXY TCP4 源地址 目标地址 源端口 目标端口\r\n
+func readProxyV1(br *bufio.Reader) (*ProxyHeader, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeader
+	}
+
+	var err error
+	if header.SourceAddr, err = parseProxyV1Addr(fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if header.DestAddr, err = parseProxyV1Addr(fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func parseProxyV1Addr(ip string, port string) (net.Addr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, ErrProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+//v2二进制格式：签名12字节 版本及命令1字节 地址族及协议1字节 长度2字节 地址 TLV
+func readProxyV2(br *bufio.Reader) (*ProxyHeader, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, ErrProxyHeader
+	}
+	command := head[12] & 0x0f
+	family := head[13] >> 4
+	payload := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2}
+	switch command {
+	case 0x0:
+		header.Local = true
+		return header, nil
+	case 0x1:
+	default:
+		return nil, ErrProxyHeader
+	}
+
+	var rest []byte
+	switch family {
+	case 0x1: //AF_INET
+		if len(payload) < 12 {
+			return nil, ErrProxyHeader
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.DestAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		rest = payload[12:]
+	case 0x2: //AF_INET6
+		if len(payload) < 36 {
+			return nil, ErrProxyHeader
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.DestAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		rest = payload[36:]
+	case 0x3: //AF_UNIX
+		if len(payload) < 216 {
+			return nil, ErrProxyHeader
+		}
+		header.SourceAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[0:108], "\x00")), Net: "unix"}
+		header.DestAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: "unix"}
+		rest = payload[216:]
+	default: //AF_UNSPEC，地址保持不变
+		header.Local = true
+		return header, nil
+	}
+
+	for len(rest) > 0 {
+		if len(rest) < 3 {
+			return nil, ErrProxyHeader
+		}
+		length := int(binary.BigEndian.Uint16(rest[1:3]))
+		if len(rest) < 3+length {
+			return nil, ErrProxyHeader
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: rest[0], Value: rest[3 : 3+length]})
+		rest = rest[3+length:]
+	}
+	return header, nil
+}
+
+//使用协议头中真实地址的连接
+type proxyConn struct {
+	net.Conn
+	br     *bufio.Reader
+	header *ProxyHeader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header != nil && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header != nil && c.header.DestAddr != nil {
+		return c.header.DestAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+//获取底层连接
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+//获取连接的PROXY协议头，未启用或没有协议头时返回false
+func GetProxyHeader(c IConnection) (*ProxyHeader, bool) {
+	pc, ok := c.GetConn().(*proxyConn)
+	if !ok || pc.header == nil {
+		return nil, false
+	}
+	return pc.header, true
+}
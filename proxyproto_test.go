@@ -0,0 +1,232 @@
+package znets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//构造v2协议头，payload为地址及TLV
+func proxyV2(command, family byte, payload []byte) []byte {
+	buf := append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x20|command, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(buf[14:16], uint16(len(payload)))
+	return append(buf, payload...)
+}
+
+func proxyTLV(t byte, value string) []byte {
+	return append([]byte{t, byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+func inet4Payload() []byte {
+	p := []byte{10, 0, 0, 1, 192, 168, 0, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(p[8:10], 5000)
+	binary.BigEndian.PutUint16(p[10:12], 443)
+	return p
+}
+
+func inet6Payload() []byte {
+	p := make([]byte, 36)
+	copy(p[0:16], net.ParseIP("2001:db8::1"))
+	copy(p[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(p[32:34], 5000)
+	binary.BigEndian.PutUint16(p[34:36], 443)
+	return p
+}
+
+func unixPayload() []byte {
+	p := make([]byte, 216)
+	copy(p[0:108], "/tmp/src.sock")
+	copy(p[108:216], "/tmp/dst.sock")
+	return p
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	cases := []struct {
+		name   string
+		data   []byte
+		err    error
+		header *ProxyHeader
+		tlvs   map[byte]string
+	}{
+		{
+			name:   "v1 tcp4",
+			data:   []byte("PROXY TCP4 10.0.0.1 192.168.0.1 5000 443\r\n"),
+			header: &ProxyHeader{Version: 1, SourceAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, DestAddr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 443}},
+		},
+		{
+			name:   "v1 tcp6",
+			data:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 5000 443\r\n"),
+			header: &ProxyHeader{Version: 1, SourceAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, DestAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		},
+		{
+			name:   "v1 unknown",
+			data:   []byte("PROXY UNKNOWN\r\n"),
+			header: &ProxyHeader{Version: 1, Local: true},
+		},
+		{name: "v1 bad port", data: []byte("PROXY TCP4 10.0.0.1 192.168.0.1 70000 443\r\n"), err: ErrProxyHeader},
+		{name: "v1 bad ip", data: []byte("PROXY TCP4 10.0.0 192.168.0.1 5000 443\r\n"), err: ErrProxyHeader},
+		{name: "v1 bad protocol", data: []byte("PROXY UDP4 10.0.0.1 192.168.0.1 5000 443\r\n"), err: ErrProxyHeader},
+		{name: "v1 missing cr", data: []byte("PROXY TCP4 10.0.0.1 192.168.0.1 5000 443\n"), err: ErrProxyHeader},
+		{name: "v1 truncated", data: []byte("PROXY TCP4 10.0.0.1"), err: io.EOF},
+		{name: "v1 oversized", data: []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLength) + "\r\n"), err: ErrProxyHeader},
+		{
+			name:   "v2 inet",
+			data:   proxyV2(0x1, 0x1, inet4Payload()),
+			header: &ProxyHeader{Version: 2, SourceAddr: &net.TCPAddr{IP: net.IP{10, 0, 0, 1}, Port: 5000}, DestAddr: &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 443}},
+		},
+		{
+			name:   "v2 inet6",
+			data:   proxyV2(0x1, 0x2, inet6Payload()),
+			header: &ProxyHeader{Version: 2, SourceAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, DestAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		},
+		{
+			name:   "v2 unix",
+			data:   proxyV2(0x1, 0x3, unixPayload()),
+			header: &ProxyHeader{Version: 2, SourceAddr: &net.UnixAddr{Name: "/tmp/src.sock", Net: "unix"}, DestAddr: &net.UnixAddr{Name: "/tmp/dst.sock", Net: "unix"}},
+		},
+		{
+			name:   "v2 local",
+			data:   proxyV2(0x0, 0x0, nil),
+			header: &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:   "v2 tlv",
+			data:   proxyV2(0x1, 0x1, append(append(inet4Payload(), proxyTLV(PP2_TYPE_ALPN, "h2")...), proxyTLV(PP2_TYPE_AUTHORITY, "example.com")...)),
+			header: &ProxyHeader{Version: 2, SourceAddr: &net.TCPAddr{IP: net.IP{10, 0, 0, 1}, Port: 5000}, DestAddr: &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 443}},
+			tlvs:   map[byte]string{PP2_TYPE_ALPN: "h2", PP2_TYPE_AUTHORITY: "example.com"},
+		},
+		{name: "v2 truncated tlv", data: proxyV2(0x1, 0x1, append(inet4Payload(), PP2_TYPE_ALPN, 0, 5, 'h')), err: ErrProxyHeader},
+		{name: "v2 short tlv header", data: proxyV2(0x1, 0x1, append(inet4Payload(), PP2_TYPE_ALPN, 0)), err: ErrProxyHeader},
+		{name: "v2 short inet", data: proxyV2(0x1, 0x1, inet4Payload()[:8]), err: ErrProxyHeader},
+		{name: "v2 short unix", data: proxyV2(0x1, 0x3, unixPayload()[:100]), err: ErrProxyHeader},
+		{name: "v2 bad command", data: proxyV2(0x2, 0x1, inet4Payload()), err: ErrProxyHeader},
+		{name: "v2 truncated head", data: proxyV2(0x1, 0x1, nil)[:14], err: io.ErrUnexpectedEOF},
+		{name: "v2 truncated payload", data: proxyV2(0x1, 0x1, inet4Payload())[:20], err: io.ErrUnexpectedEOF},
+		{name: "no header", data: []byte("hello world"), header: nil},
+		{name: "signature prefix", data: []byte("\r\n\r\nxyz"), header: nil},
+		{name: "short signature", data: []byte("PRO"), err: io.EOF},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header, err := readProxyHeader(bufio.NewReader(bytes.NewReader(c.data)))
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err %v, want %v", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err %v", err)
+			}
+			if c.header == nil {
+				if header != nil {
+					t.Fatalf("got header %+v, want none", header)
+				}
+				return
+			}
+			if header == nil {
+				t.Fatal("no header")
+			}
+			if header.Version != c.header.Version || header.Local != c.header.Local {
+				t.Fatalf("got %+v, want %+v", header, c.header)
+			}
+			if addrString(header.SourceAddr) != addrString(c.header.SourceAddr) || addrString(header.DestAddr) != addrString(c.header.DestAddr) {
+				t.Fatalf("addr %v -> %v, want %v -> %v", header.SourceAddr, header.DestAddr, c.header.SourceAddr, c.header.DestAddr)
+			}
+			if len(header.TLVs) != len(c.tlvs) {
+				t.Fatalf("got %d tlvs, want %d", len(header.TLVs), len(c.tlvs))
+			}
+			for typ, want := range c.tlvs {
+				if got, ok := header.TLV(typ); !ok || string(got) != want {
+					t.Fatalf("tlv %#x = %q, want %q", typ, got, want)
+				}
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestProxyAccept(t *testing.T) {
+	cases := []struct {
+		name     string
+		required bool
+		data     []byte
+		err      bool
+		remote   string
+		rest     string
+	}{
+		{name: "header", data: append([]byte("PROXY TCP4 10.0.0.1 192.168.0.1 5000 443\r\n"), "data"...), remote: "10.0.0.1:5000", rest: "data"},
+		{name: "no header", data: []byte("data"), rest: "data"},
+		{name: "short first frame", data: []byte("PRO"), rest: "PRO"},
+		{name: "server first", rest: ""},
+		{name: "required short first frame", required: true, data: []byte("PRO"), err: true},
+		{name: "required no header", required: true, data: []byte("data"), err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := newProxyProtocol(&ProxyProtocolOptions{Trusted: []string{"127.0.0.1"}, Timeout: 50 * time.Millisecond, Required: c.required})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			go client.Write(c.data)
+
+			conn, err := p.accept(server)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("accept: %v", err)
+			}
+			if c.remote != "" && conn.RemoteAddr().String() != c.remote {
+				t.Fatalf("remote %s, want %s", conn.RemoteAddr(), c.remote)
+			}
+			if c.rest == "" {
+				return
+			}
+			buf := make([]byte, len(c.rest))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(buf) != c.rest {
+				t.Fatalf("read %q, want %q", buf, c.rest)
+			}
+		})
+	}
+}
+
+func TestProxyRequiresTrusted(t *testing.T) {
+	if _, err := newProxyProtocol(&ProxyProtocolOptions{}); !errors.Is(err, ErrProxyNoTrusted) {
+		t.Fatalf("err %v, want %v", err, ErrProxyNoTrusted)
+	}
+	p, err := newProxyProtocol(&ProxyProtocolOptions{Trusted: []string{"10.0.0.0/8", "2001:db8::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{"10.1.2.3": true, "11.0.0.1": false, "2001:db8::1": true, "2001:db8::2": false} {
+		if got := p.isTrusted(&net.TCPAddr{IP: net.ParseIP(addr)}); got != want {
+			t.Fatalf("isTrusted(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
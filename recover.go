@@ -0,0 +1,77 @@
+package znets
+
+import "runtime/debug"
+
+type PanicHandler func(request IRequest, recovered interface{}, stack []byte)
+type ErrorHandler func(ctx IContext, err error)
+
+//将返回错误的处理方法转换为HandlerFunc，返回错误时记录到上下文并终断调用链
+func WrapE(fn HandlerFuncE) HandlerFunc {
+	return func(ctx IContext) {
+		if err := fn(ctx); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}
+}
+
+//设置处理方法panic时的回调，未设置时记录错误日志及调用栈
+//连接事件回调panic时同样回调，此时request为nil
+func (h *Handler) OnPanic(fn PanicHandler) {
+	h.onPanic = fn
+}
+
+//设置处理方法错误的统一回调，可在回调中回复、记录或关闭连接，未设置时记录错误日志
+func (h *Handler) OnError(fn ErrorHandler) {
+	h.onError = fn
+}
+
+//执行调用链，恢复panic避免工作协程退出
+func (h *Handler) safeRun(request IRequest, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.handlePanic(request, request.GetConnection(), r, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+//执行连接事件回调(OnConnect、OnClose、OnIdle及连接hook)，恢复panic，回调OnPanic时request为nil
+func (h *Handler) safeRunEvent(c IConnection, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.handlePanic(nil, c, r, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+func (h *Handler) handlePanic(request IRequest, c IConnection, recovered interface{}, stack []byte) {
+	if h.onPanic == nil {
+		if request == nil {
+			Log.Error("event panic:%v, ConnID = %d\n%s", recovered, c.GetID(), stack)
+		} else {
+			Log.Error("handler panic:%v, ConnID = %d, MsgId = %d\n%s", recovered, c.GetID(), request.GetID(), stack)
+		}
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			Log.Error("OnPanic panic:%v, ConnID = %d\n%s", r, c.GetID(), debug.Stack())
+		}
+	}()
+	h.onPanic(request, recovered, stack)
+}
+
+//统一处理调用链中记录的错误
+func (h *Handler) handleErrors(ctx IContext) {
+	for _, err := range ctx.Errors() {
+		if h.onError == nil {
+			Log.Error("handler err:%s, ConnID = %d, MsgId = %d", err.Error(), ctx.GetConnection().GetID(), ctx.GetID())
+			continue
+		}
+		h.safeRun(ctx, func() {
+			h.onError(ctx, err)
+		})
+	}
+}
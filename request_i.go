@@ -1,12 +1,18 @@
 package znets
 
+import "context"
+
 type IRequest interface {
 	GetConnection() IConnection
+	Context() context.Context //连接关闭、server停止或处理超时时取消
+	setContext(context.Context)
 	GetData() []byte
 
 	GetID() uint32
 	GetLen() uint32
-	getRid() *uint32
+	GetSeq() uint32
+	Reply(data []byte) error //回复请求
+	GetRid() uint64          //获取请求id
 	SetWorkId(uint32)
 
 	GetWorkId() uint32
@@ -0,0 +1,135 @@
+package znets
+
+import "time"
+
+//路由，按消息id将请求分发到注册的处理方法
+type Router struct {
+	routes map[uint32]*route //精确匹配的路由
+	groups []*RouteGroup     //按消息id区间匹配的路由组
+}
+
+type route struct {
+	handler     HandlerFunc   //处理方法
+	middlewares []HandlerFunc //路由中间件
+	timeout     time.Duration //处理超时时间，0使用路由组或默认的超时时间
+	group       *RouteGroup   //所属路由组
+}
+
+//消息id区间 [min, max] 的路由组
+type RouteGroup struct {
+	min         uint32
+	max         uint32
+	middlewares []HandlerFunc //路由组中间件，作用于组内所有路由
+	timeout     time.Duration //组内路由的处理超时时间
+	routes      map[uint32]*route
+	fallback    *route //组内未注册的消息id的处理
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[uint32]*route),
+		groups: make([]*RouteGroup, 0),
+	}
+}
+
+//注册消息id的处理方法及路由中间件
+func (r *Router) Handle(msgId uint32, handler HandlerFunc, middlewares ...HandlerFunc) {
+	if _, ok := r.routes[msgId]; ok {
+		Log.Warning("Route %d is overwritten", msgId)
+	}
+	r.routes[msgId] = &route{
+		handler:     handler,
+		middlewares: middlewares,
+	}
+}
+
+//注册消息id的处理方法并设置处理超时时间
+func (r *Router) HandleTimeout(msgId uint32, timeout time.Duration, handler HandlerFunc, middlewares ...HandlerFunc) {
+	r.Handle(msgId, handler, middlewares...)
+	r.routes[msgId].timeout = timeout
+}
+
+//注册消息id区间的路由组，区间重叠时先注册的优先
+func (r *Router) HandleGroup(min, max uint32, middlewares ...HandlerFunc) *RouteGroup {
+	if min > max {
+		min, max = max, min
+	}
+	g := &RouteGroup{
+		min:         min,
+		max:         max,
+		middlewares: middlewares,
+		routes:      make(map[uint32]*route),
+	}
+	r.groups = append(r.groups, g)
+	return g
+}
+
+//查找消息id对应的路由，未找到返回nil
+func (r *Router) match(msgId uint32) *route {
+	if rt, ok := r.routes[msgId]; ok {
+		return rt
+	}
+	for _, g := range r.groups {
+		if msgId < g.min || msgId > g.max {
+			continue
+		}
+		if rt, ok := g.routes[msgId]; ok {
+			return rt
+		}
+		if g.fallback != nil {
+			return g.fallback
+		}
+	}
+	return nil
+}
+
+//在路由组内注册消息id的处理方法，消息id需在组区间内
+func (g *RouteGroup) Handle(msgId uint32, handler HandlerFunc, middlewares ...HandlerFunc) *RouteGroup {
+	if msgId < g.min || msgId > g.max {
+		Log.Error("Route %d is out of group range [%d, %d]", msgId, g.min, g.max)
+		return g
+	}
+	g.routes[msgId] = g.newRoute(handler, middlewares)
+	return g
+}
+
+//在路由组内注册消息id的处理方法并设置处理超时时间
+func (g *RouteGroup) HandleTimeout(msgId uint32, timeout time.Duration, handler HandlerFunc, middlewares ...HandlerFunc) *RouteGroup {
+	g.Handle(msgId, handler, middlewares...)
+	if rt, ok := g.routes[msgId]; ok {
+		rt.timeout = timeout
+	}
+	return g
+}
+
+//设置组内路由的处理超时时间，路由单独设置的优先
+func (g *RouteGroup) Timeout(timeout time.Duration) *RouteGroup {
+	g.timeout = timeout
+	return g
+}
+
+//设置组内未注册消息id的处理方法
+func (g *RouteGroup) Default(handler HandlerFunc, middlewares ...HandlerFunc) *RouteGroup {
+	g.fallback = g.newRoute(handler, middlewares)
+	return g
+}
+
+//组中间件在路由中间件之前执行
+func (g *RouteGroup) newRoute(handler HandlerFunc, middlewares []HandlerFunc) *route {
+	mws := make([]HandlerFunc, 0, len(g.middlewares)+len(middlewares))
+	mws = append(mws, g.middlewares...)
+	mws = append(mws, middlewares...)
+	return &route{
+		handler:     handler,
+		middlewares: mws,
+		group:       g,
+	}
+}
+
+//路由的处理超时时间，未设置时返回0
+func (rt *route) getTimeout() time.Duration {
+	if rt.timeout == 0 && rt.group != nil {
+		return rt.group.timeout
+	}
+	return rt.timeout
+}
@@ -0,0 +1,105 @@
+package znets
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	SEQ_REPLY = 1 << 31   //响应消息的seq标记，协议编码时写入seq字段的最高位
+	MAX_SEQ   = 1<<15 - 1 //Call分配的最大seq，兼容2字节的seq字段
+)
+
+var (
+	ErrSeqNotSupported = errors.New("codec does not support seq")
+	ErrTooManyCalls    = errors.New("too many pending calls")
+)
+
+//发送请求并等待对端响应，按seq匹配响应，连接关闭时返回ErrConnectionClosed
+//需要协议支持seq字段，如设置了SeqFieldLength的LengthFieldPack，否则返回ErrSeqNotSupported
+func (c *Connection) Call(ctx context.Context, msgId uint32, payload []byte) (IMessage, error) {
+	if !supportSeq(c.codec) {
+		return nil, ErrSeqNotSupported
+	}
+	seq, ch, err := c.addCall()
+	if err != nil {
+		return nil, err
+	}
+	defer c.removeCall(seq)
+
+	err = c.SendMessage(&Message{
+		Id:     msgId,
+		Seq:    seq,
+		Length: uint32(len(payload)),
+		Data:   payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return nil, ErrConnectionClosed
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//分配seq，跳过仍在等待响应的seq，seq全部在使用时返回ErrTooManyCalls
+func (c *Connection) addCall() (uint32, chan IMessage, error) {
+	ch := make(chan IMessage, 1)
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	if len(c.calls) >= MAX_SEQ {
+		return 0, nil, ErrTooManyCalls
+	}
+	for {
+		seq := atomic.AddUint32(&c.seq, 1)%MAX_SEQ + 1
+		if _, ok := c.calls[seq]; !ok {
+			c.calls[seq] = ch
+			return seq, ch, nil
+		}
+	}
+}
+
+func (c *Connection) removeCall(seq uint32) {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	delete(c.calls, seq)
+}
+
+//收到响应消息时唤醒对应的Call，返回true表示已处理
+func (c *Connection) resolveCall(msg IMessage) bool {
+	if msg.GetSeq()&SEQ_REPLY == 0 {
+		return false
+	}
+
+	c.callsLock.Lock()
+	ch, ok := c.calls[msg.GetSeq()&^SEQ_REPLY]
+	delete(c.calls, msg.GetSeq()&^SEQ_REPLY)
+	c.callsLock.Unlock()
+
+	if ok {
+		ch <- msg
+	} else {
+		Log.Warning("no pending call for reply seq %d, ConnID = %d", msg.GetSeq()&^SEQ_REPLY, c.ConnID)
+	}
+	return true
+}
+
+//连接关闭时等待中的Call全部失败
+func (c *Connection) failCalls() {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	for seq, ch := range c.calls {
+		close(ch)
+		delete(c.calls, seq)
+	}
+}
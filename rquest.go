@@ -1,15 +1,18 @@
 package znets
 
+import "context"
+
 type Request struct {
-	conn     IConnection //已建立的连接
-	msg      IMessage    //客户端请求的数据
-	rid      *uint32     //当前Request的ID
-	workId   uint32      //工作池内标识id
-	clientId string      //客户端连接记录标识id
+	conn     IConnection     //已建立的连接
+	msg      IMessage        //客户端请求的数据
+	rid      uint64          //请求id，全局单调递增
+	workId   uint32          //工作池内标识id
+	clientId string          //客户端连接记录标识id
+	ctx      context.Context //请求的context，处理时设置超时时间
 }
 
 //实例化,rid:全局请求id
-func NewRequest(con IConnection, msg IMessage, rid *uint32, clientId string) IRequest {
+func NewRequest(con IConnection, msg IMessage, rid uint64, clientId string) IRequest {
 	return &Request{
 		conn:     con,
 		msg:      msg,
@@ -18,6 +21,18 @@ func NewRequest(con IConnection, msg IMessage, rid *uint32, clientId string) IRe
 	}
 }
 
+//获取请求的context，连接关闭、server停止或处理超时时取消
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return r.conn.Context()
+}
+
+func (r *Request) setContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
 //获取当前连接
 func (r *Request) GetConnection() IConnection {
 	return r.conn
@@ -33,13 +48,37 @@ func (r *Request) GetID() uint32 {
 	return r.msg.GetId()
 }
 
+//获取请求响应的序号
+func (r *Request) GetSeq() uint32 {
+	return r.msg.GetSeq()
+}
+
+//回复请求，响应消息使用相同的消息id及seq，对端Call/Request据此匹配
+func (r *Request) Reply(data []byte) error {
+	return r.conn.SendMessage(replyMessage(r, data))
+}
+
+//请求的响应消息，使用相同的消息id，seq带响应标记
+func replyMessage(rq IRequest, data []byte) *Message {
+	seq := rq.GetSeq()
+	if seq != 0 {
+		seq |= SEQ_REPLY
+	}
+	return &Message{
+		Id:     rq.GetID(),
+		Seq:    seq,
+		Length: uint32(len(data)),
+		Data:   data,
+	}
+}
+
 //获取数据长度
 func (r *Request) GetLen() uint32 {
 	return r.msg.GetLen()
 }
 
 //获取全局请求序号id
-func (r *Request) getRid() *uint32 {
+func (r *Request) GetRid() uint64 {
 	return r.rid
 }
 
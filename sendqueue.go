@@ -0,0 +1,133 @@
+package znets
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+//发送队列满时的处理策略
+const (
+	SendBlock      = "block"       //阻塞等待，超过发送超时时间返回错误
+	SendDropNewest = "drop-newest" //丢弃当前发送的数据
+	SendDropOldest = "drop-oldest" //丢弃队列中最早的数据
+	SendDisconnect = "disconnect"  //断开连接
+
+	DEFAULT_SEND_QUEUE_SIZE = 1024
+	MAX_WRITE_BATCH         = 64 //单次合并写入的最大帧数
+)
+
+var (
+	ErrConnectionClosed = errors.New("Connection closes")
+	ErrSendQueueFull    = errors.New("send queue is full")
+	ErrSendTimeout      = errors.New("send timeout")
+)
+
+//设置发送队列，需在Start之前设置
+func (c *Connection) SetSendQueue(size int, policy string, timeout time.Duration) {
+	if size <= 0 {
+		size = DEFAULT_SEND_QUEUE_SIZE
+	}
+	if policy == "" {
+		policy = SendBlock
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_WRITE_TIMEOUT
+	}
+	c.dataChan = make(chan []byte, size)
+	c.sendPolicy = policy
+	c.sendTimeout = timeout
+}
+
+//发送队列中待发送的数据数量
+func (c *Connection) QueueLen() int {
+	return len(c.dataChan)
+}
+
+//发送队列容量
+func (c *Connection) QueueCap() int {
+	return cap(c.dataChan)
+}
+
+//数据写入发送队列，nil为关闭连接信号，不受丢弃策略影响
+func (c *Connection) enqueue(data []byte) error {
+	if data == nil {
+		return c.enqueueWait(data)
+	}
+
+	switch c.sendPolicy {
+	case SendDropNewest:
+		select {
+		case c.dataChan <- data:
+			return nil
+		case <-c.ExitChan:
+			return ErrConnectionClosed
+		default:
+			return ErrSendQueueFull
+		}
+
+	case SendDropOldest:
+		for {
+			select {
+			case c.dataChan <- data:
+				return nil
+			case <-c.ExitChan:
+				return ErrConnectionClosed
+			default:
+			}
+			select {
+			case old := <-c.dataChan:
+				//丢弃的是关闭信号，之前的数据都已写完，直接关闭
+				if old == nil {
+					go c.closeConn()
+					return ErrConnectionClosed
+				}
+			default:
+			}
+		}
+
+	case SendDisconnect:
+		select {
+		case c.dataChan <- data:
+			return nil
+		case <-c.ExitChan:
+			return ErrConnectionClosed
+		default:
+			Log.Error("send queue is full, disconnect ConnID = %d", c.ConnID)
+			go c.Stop()
+			return ErrSendQueueFull
+		}
+	}
+	return c.enqueueWait(data)
+}
+
+//阻塞写入发送队列，超时返回错误
+func (c *Connection) enqueueWait(data []byte) error {
+	timer := time.NewTimer(c.sendTimeout)
+	defer timer.Stop()
+
+	select {
+	case c.dataChan <- data:
+		return nil
+	case <-c.ExitChan:
+		return ErrConnectionClosed
+	case <-timer.C:
+		return ErrSendTimeout
+	}
+}
+
+//取出队列中已有的数据合并写入，返回是否收到关闭信号
+func (c *Connection) collectBatch(buffers net.Buffers) (net.Buffers, bool) {
+	for len(buffers) < MAX_WRITE_BATCH {
+		select {
+		case data := <-c.dataChan:
+			if data == nil {
+				return buffers, true
+			}
+			buffers = append(buffers, data)
+		default:
+			return buffers, false
+		}
+	}
+	return buffers, false
+}
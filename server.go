@@ -1,78 +1,218 @@
 package znets
 
 import (
-	"encoding/hex"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
 const (
 	DEV        = "dev"
 	PRODUCTION = "production"
+
+	NetworkTCP  = "tcp"
+	NetworkUDP  = "udp"
+	NetworkWS   = "ws"
+	NetworkUnix = "unix"
+
+	IPv4      = "tcp4"
+	IPv6      = "tcp6"
+	DualStack = "tcp"
 )
 
 type Options struct {
 	IP             string
 	Port           int
+	IPVersion      string //IP协议版本 tcp4|tcp6|tcp，tcp为双栈，默认tcp4
 	MaxConnections uint32
 	Model          string //运行模式 dev|production
 	MaxConnNum     uint32
 	WorkPool       uint32
+	Dispatch       string //工作池分发策略 affinity|round-robin|least-loaded|unordered，默认round-robin
 	PidFilePath    string //pid保存路径
+	Charset        string //通讯字符集 none|gbk|gb18030|big5|shift-jis|utf-16le|utf-16be，默认gbk
+
+	ReadIdleTimeout  time.Duration //读空闲时间，0表示不检测
+	WriteIdleTimeout time.Duration //写空闲时间，0表示不检测
+	AllIdleTimeout   time.Duration //读写空闲时间，0表示不检测
+	Heartbeat        *Heartbeat    //内置心跳，nil表示不启用
+
+	SendQueueSize int           //每个连接的发送队列大小，默认1024
+	SendPolicy    string        //发送队列满时的处理策略 block|drop-newest|drop-oldest|disconnect，默认block
+	SendTimeout   time.Duration //block策略下的发送超时时间，默认60s
+
+	MaxWorkPool       uint32        //最大工作协程数，大于WorkPool时按共享队列的排队延迟扩容，affinity分发策略下不生效，默认等于WorkPool
+	TaskQueueSize     int           //每个工作协程及共享队列的长度，默认1024
+	ScaleLatency      time.Duration //共享队列排队超过该时间时扩容，默认100ms
+	WorkerIdleTimeout time.Duration //扩容的工作协程空闲超过该时间退出，默认60s
+	RejectPolicy      string        //任务队列满时的处理策略 block|drop|busy，默认block
+	BusyReply         []byte        //busy策略回复的数据
+	HandlerTimeout    time.Duration //请求处理的默认超时时间，超时后取消请求的context，0表示不限制
+
+	ClientIdType   string //clientId生成方式 address|hmac|random|snowflake，默认address
+	ClientIdSecret string //hmac方式的签名密钥
+	NodeId         int64  //snowflake方式的节点id 0-1023
+
+	TLS           *TLSOptions           //TLS设置，nil表示不启用
+	ProxyProtocol *ProxyProtocolOptions //PROXY协议设置，nil表示不启用
+
+	Network           string            //传输协议 tcp|udp|ws|unix，默认tcp
+	SocketPath        string            //unix方式的socket文件路径，以@开头时为abstract socket
+	SocketFileMode    os.FileMode       //unix方式的socket文件权限，0表示不修改
+	UDPSessionTimeout time.Duration     //UDP虚拟连接超过该时间未收到数据则关闭，默认60s
+	WebSocket         *WebSocketOptions //WebSocket设置，ws方式时nil使用默认设置
 }
 
 type Server struct {
+	rid uint64 //请求id生成，64位原子操作的字段放在开头保证对齐
+
 	IP             string
 	Port           int
 	Conn           *Listener
+	UDPConn        *UDPListener
 	IPVersion      string
 	Handles        *Handler
 	Version        string
-	cid            uint32  //当前连接数
-	rids           *uint32 //当前请求数
-	maxConnections uint32  //最大连接数
+	ctx            context.Context //server停止时取消，连接的context由此派生
+	cancel         context.CancelFunc
+	cid            uint32 //连接id生成，即累计接入的连接数
+	maxConnections uint32 //最大连接数
 	manager        IManager
 	overload       overloadHandler
 	onStart        hookHandler
 	onStop         hookHandler
 
-	protoPack IPack
+	codec   ICodec   //协议编解码
+	charset ICharset //通讯字符集转换
+
+	readIdleTimeout  time.Duration //读空闲时间
+	writeIdleTimeout time.Duration //写空闲时间
+	allIdleTimeout   time.Duration //读写空闲时间
+	heartbeat        *Heartbeat    //内置心跳
+
+	sendQueueSize int           //发送队列大小
+	sendPolicy    string        //发送队列满时的处理策略
+	sendTimeout   time.Duration //发送超时时间
+
+	clientIdGenerator IClientIdGenerator //clientId生成器
+	tls               *tlsReloader       //TLS配置，nil表示不启用
+	proxy             *proxyProtocol     //PROXY协议解析，nil表示不启用
+
+	network           string        //传输协议 tcp|udp
+	udpSessionTimeout time.Duration //UDP虚拟连接超时时间
+	websocket         *WebSocketOptions
+	socketPath        string
+	socketFileMode    os.FileMode
+	listeners         []*serverListener //通过AddListener添加的监听，启动后包含Options中设置的监听
 
 	config   *viper.Viper //配置文件对象
 	runModel string       //运行模式 dev|production
 
 	pidFilePath string //pid保存路径
 
-	isExit bool //循环监听中是否需要退出
+	isExit int32 //循环监听中是否需要退出，原子读写
 }
 
 var version string = "v1.0.2"
-var Log *HLog
+var Log *HLog = NewLog() //默认dev模式，构建server时按运行模式替换，client等未创建server时也可使用
 
 //通过配置文件构建默认server
 func NewServer() *Server {
 	config := parseConfigFile()
-	ip := config.GetString("Server.Ip")
-	port := config.GetInt("Server.Port")
-	model := config.GetString("Server.Model")
-	maxConnNum := config.GetUint32("Server.MaxConnNum")
-	workPool := config.GetUint32("Server.WorkPoll")
-	pidFilePath := config.GetString("Server.PidFilePath")
+	options := &Options{
+		IP:          config.GetString("Server.Ip"),
+		Port:        config.GetInt("Server.Port"),
+		IPVersion:   config.GetString("Server.IPVersion"),
+		Model:       config.GetString("Server.Model"),
+		MaxConnNum:  config.GetUint32("Server.MaxConnNum"),
+		WorkPool:    config.GetUint32("Server.WorkPoll"),
+		Dispatch:    config.GetString("Server.Dispatch"),
+		PidFilePath: config.GetString("Server.PidFilePath"),
+		Charset:     config.GetString("Server.Charset"),
+
+		ReadIdleTimeout:  config.GetDuration("Server.ReadIdleTimeout"),
+		WriteIdleTimeout: config.GetDuration("Server.WriteIdleTimeout"),
+		AllIdleTimeout:   config.GetDuration("Server.AllIdleTimeout"),
+
+		SendQueueSize: config.GetInt("Server.SendQueueSize"),
+		SendPolicy:    config.GetString("Server.SendPolicy"),
+		SendTimeout:   config.GetDuration("Server.SendTimeout"),
+
+		MaxWorkPool:       config.GetUint32("Server.MaxWorkPool"),
+		TaskQueueSize:     config.GetInt("Server.TaskQueueSize"),
+		ScaleLatency:      config.GetDuration("Server.ScaleLatency"),
+		WorkerIdleTimeout: config.GetDuration("Server.WorkerIdleTimeout"),
+		RejectPolicy:      config.GetString("Server.RejectPolicy"),
+		BusyReply:         []byte(config.GetString("Server.BusyReply")),
+		HandlerTimeout:    config.GetDuration("Server.HandlerTimeout"),
+
+		ClientIdType:   config.GetString("Server.ClientId.Type"),
+		ClientIdSecret: config.GetString("Server.ClientId.Secret"),
+		NodeId:         config.GetInt64("Server.ClientId.NodeId"),
+	}
+	options.Network = config.GetString("Server.Network")
+	options.UDPSessionTimeout = config.GetDuration("Server.UDPSessionTimeout")
+	options.SocketPath = config.GetString("Server.SocketPath")
+	if mode := config.GetString("Server.SocketFileMode"); mode != "" {
+		fileMode, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			panic("socket文件权限格式错误：" + mode)
+		}
+		options.SocketFileMode = os.FileMode(fileMode)
+	}
+	if config.IsSet("Server.WebSocket") {
+		options.WebSocket = &WebSocketOptions{
+			Path:           config.GetString("Server.WebSocket.Path"),
+			Text:           config.GetBool("Server.WebSocket.Text"),
+			Compress:       config.GetBool("Server.WebSocket.Compress"),
+			MaxMessageSize: config.GetInt("Server.WebSocket.MaxMessageSize"),
+			PingInterval:   config.GetDuration("Server.WebSocket.PingInterval"),
+		}
+	}
+	if config.GetBool("Server.ProxyProtocol.Enable") {
+		options.ProxyProtocol = &ProxyProtocolOptions{
+			Trusted:  config.GetStringSlice("Server.ProxyProtocol.Trusted"),
+			Timeout:  config.GetDuration("Server.ProxyProtocol.Timeout"),
+			Required: config.GetBool("Server.ProxyProtocol.Required"),
+		}
+	}
+	if config.GetString("Server.TLS.CertFile") != "" {
+		options.TLS = &TLSOptions{
+			CertFile:       config.GetString("Server.TLS.CertFile"),
+			KeyFile:        config.GetString("Server.TLS.KeyFile"),
+			ClientCAFile:   config.GetString("Server.TLS.ClientCAFile"),
+			MinVersion:     config.GetString("Server.TLS.MinVersion"),
+			CipherSuites:   config.GetStringSlice("Server.TLS.CipherSuites"),
+			ReloadInterval: config.GetDuration("Server.TLS.ReloadInterval"),
+		}
+	}
+	if config.IsSet("Server.Heartbeat") {
+		options.Heartbeat = &Heartbeat{
+			PingId:   config.GetUint32("Server.Heartbeat.PingId"),
+			PingData: []byte(config.GetString("Server.Heartbeat.PingData")),
+			PongId:   config.GetUint32("Server.Heartbeat.PongId"),
+			PongData: []byte(config.GetString("Server.Heartbeat.PongData")),
+			MaxMiss:  config.GetInt("Server.Heartbeat.MaxMiss"),
+		}
+	}
 
-	return buildServ(ip, port, model, maxConnNum, workPool, pidFilePath, config)
+	return buildServ(options, config)
 }
 
 //使用Options字段构建server
 func NewServerWithOptions(options *Options) *Server {
+	return buildServ(options, nil)
+}
+
+func buildServ(options *Options, config *viper.Viper) *Server {
 	ip := options.IP
 	port := options.Port
 	model := options.Model
@@ -80,12 +220,9 @@ func NewServerWithOptions(options *Options) *Server {
 	workPool := options.WorkPool
 	pidFilePath := options.PidFilePath
 
-	return buildServ(ip, port, model, maxConnNum, workPool, pidFilePath, nil)
-}
-
-func buildServ(ip string, port int, model string, maxConnNum, workPool uint32, pidFilePath string, config *viper.Viper) *Server {
-	if ip == "" {
-		ip = "0.0.0.0"
+	ipVersion, ip, err := checkIPVersion(options.IPVersion, ip)
+	if err != nil {
+		panic(err.Error())
 	}
 	if port == 0 {
 		port = 9503
@@ -100,6 +237,15 @@ func buildServ(ip string, port int, model string, maxConnNum, workPool uint32, p
 		workPool = 10
 	}
 
+	network, err := checkNetwork(options.Network, options.SocketPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	udpSessionTimeout := options.UDPSessionTimeout
+	if udpSessionTimeout <= 0 {
+		udpSessionTimeout = DEFAULT_READ_TIMEOUT
+	}
+
 	if len(pidFilePath) == 0 {
 		path, _ := os.Getwd()
 		pidFilePath = path + "/pid"
@@ -108,21 +254,73 @@ func buildServ(ip string, port int, model string, maxConnNum, workPool uint32, p
 	s := &Server{
 		IP:             ip,
 		Port:           port,
-		IPVersion:      "tcp4",
+		IPVersion:      ipVersion,
 		Version:        version,
 		cid:            0,
-		rids:           new(uint32),
 		maxConnections: maxConnNum,
 		Handles:        NewHandler(),
 		manager:        NewManager(),
 		runModel:       model,
 		pidFilePath:    pidFilePath,
-		isExit:         false,
+
+		network:           network,
+		udpSessionTimeout: udpSessionTimeout,
+		socketPath:        options.SocketPath,
+		socketFileMode:    options.SocketFileMode,
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	if config != nil {
 		s.SetConfig(config)
 	}
 	s.SetWorkPoolSize(workPool)
+	s.SetDispatch(options.Dispatch)
+	s.SetTaskQueue(options.TaskQueueSize, options.RejectPolicy, options.BusyReply)
+	maxWorkPool := options.MaxWorkPool
+	if maxWorkPool < workPool {
+		maxWorkPool = workPool
+	}
+	s.SetWorkPoolScale(maxWorkPool, options.ScaleLatency, options.WorkerIdleTimeout)
+	s.SetHandlerTimeout(options.HandlerTimeout)
+
+	charsetName := options.Charset
+	if charsetName == "" && network == NetworkWS {
+		charsetName = CharsetNone //WebSocket的text帧要求UTF-8
+	}
+	if charsetName == "" {
+		charsetName = CharsetGBK
+	}
+	charset, err := GetCharset(charsetName)
+	if err != nil {
+		Log.Error("%s, use %s", err.Error(), CharsetGBK)
+		charset, _ = GetCharset(CharsetGBK)
+	}
+	s.SetCharset(charset)
+
+	s.SetIdleTimeout(options.ReadIdleTimeout, options.WriteIdleTimeout, options.AllIdleTimeout)
+	s.SetHeartbeat(options.Heartbeat)
+	s.SetSendQueue(options.SendQueueSize, options.SendPolicy, options.SendTimeout)
+
+	generator, err := NewClientIdGenerator(options.ClientIdType, options.ClientIdSecret, options.NodeId)
+	if err != nil {
+		Log.Error("%s, use %s", err.Error(), ClientIdAddress)
+		generator = NewAddressClientIdGenerator()
+	}
+	s.SetClientIdGenerator(generator)
+
+	if network == NetworkWS {
+		s.SetWebSocket(options.WebSocket)
+	}
+
+	if options.TLS != nil {
+		if err := s.SetTLS(options.TLS); err != nil {
+			panic("TLS证书加载失败：" + err.Error())
+		}
+	}
+	if options.ProxyProtocol != nil && network != NetworkUDP {
+		if err := s.SetProxyProtocol(options.ProxyProtocol); err != nil {
+			panic("PROXY协议设置错误：" + err.Error())
+		}
+	}
 	return s
 }
 
@@ -164,56 +362,51 @@ func (s *Server) start() {
 		return
 	}
 
-	//获取TCP地址
-	addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
-	if err != nil {
-		Log.Error("Resolve tcp addr err:" + err.Error())
-		return
-	}
-
-	//监听服务器地址
-	s.Conn, err = ListenTCP(s.IPVersion, addr, s)
-	if err != nil {
-		Log.Error("Listen tcp err:" + err.Error())
-		return
+	//监听服务器地址，Options中设置的监听在最前面，优雅重启时按顺序继承文件描述符
+	s.listeners = append([]*serverListener{s.defaultListener()}, s.listeners...)
+	for _, l := range s.listeners {
+		if err := l.listen(s); err != nil {
+			Log.Error("Listener %s listen %s err:%s", l.name, l.network, err.Error())
+			s.cancel()
+			s.closeListeners()
+			return
+		}
 	}
+	s.Conn = s.listeners[0].conn
+	s.UDPConn = s.listeners[0].udpConn
 
 	s.writePid(os.Getpid()) //写入进程id
+	go listenSignals(s)
 
-	//监听成功输出
-	Log.Info("Start server success..., listen on %s:%d", s.IP, s.Port)
 	//开启工作池
 	s.Handles.RunWorkPool()
-	//循环接受用户连接
-	for {
-		if s.isExit {
-			s.Conn.Close()
-			break
-		}
-		con, err := s.Conn.Accept()
-		if err != nil {
-			Log.Error("Accept err:%s", err)
-			if strings.Contains(err.Error(), " use of closed network connection") {
-				Log.Info("连接已关闭")
-				break
-			}
-			continue
-		}
 
-		if s.manager.Num() >= int(s.maxConnections) {
-			if s.overload != nil {
-				s.overload(con)
-			}
-			con.Close()
-			continue
-		}
+	//每个监听单独接受连接
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		wg.Add(1)
+		go func(l *serverListener) {
+			defer wg.Done()
+			l.serve(s)
+		}(l)
+	}
+	wg.Wait()
+}
 
-		dealCon := NewConnection(s, con, s.cid, s.Handles, s.Conn.wg)
-		dealCon.SetProtoPack(s.protoPack)
-		s.cid++
-		go dealCon.Start()
+//关闭所有监听
+func (s *Server) closeListeners() {
+	for _, l := range s.listeners {
+		l.close()
 	}
+}
 
+//支持优雅重启的监听，与启动时的监听顺序一致
+func (s *Server) graceListeners() []graceListener {
+	listeners := make([]graceListener, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		listeners = append(listeners, l.graceListener())
+	}
+	return listeners
 }
 
 //运行服务器
@@ -223,20 +416,27 @@ func (s *Server) Run() {
 
 //停止服务器
 func (s *Server) Stop() {
-	s.Conn.Close()
+	atomic.StoreInt32(&s.isExit, 1)
+	s.cancel()
+	s.closeListeners()
 	s.manager.Clear()
 }
 
+//是否正在退出
+func (s *Server) exiting() bool {
+	return atomic.LoadInt32(&s.isExit) == 1
+}
+
+//获取server的context，Stop及stop命令时取消，优雅重启时旧进程不取消
+func (s *Server) Context() context.Context {
+	return s.ctx
+}
+
 //添加全局中间件
 func (s *Server) Use(rf HandlerFunc) {
 	s.Handles.Use(rf)
 }
 
-//终断请求
-func (s *Server) Abort() {
-	s.Handles.Abort()
-}
-
 //设置前置处理钩子
 func (s *Server) Before(rf HandlerFunc) {
 	s.Handles.Before(rf)
@@ -247,17 +447,82 @@ func (s *Server) After(rf HandlerFunc) {
 	s.Handles.After(rf)
 }
 
+//按消息id注册处理方法，未匹配的消息交由IEvent.OnMessage处理
+func (s *Server) Handle(msgId uint32, handler HandlerFunc, middlewares ...HandlerFunc) {
+	s.Handles.Handle(msgId, handler, middlewares...)
+}
+
+//注册消息id区间 [min, max] 的路由组
+func (s *Server) HandleGroup(min, max uint32, middlewares ...HandlerFunc) *RouteGroup {
+	return s.Handles.HandleGroup(min, max, middlewares...)
+}
+
+//按消息id注册处理方法并设置超时时间
+func (s *Server) HandleTimeout(msgId uint32, timeout time.Duration, handler HandlerFunc, middlewares ...HandlerFunc) {
+	s.Handles.HandleTimeout(msgId, timeout, handler, middlewares...)
+}
+
 //设置工作池大小
 func (s *Server) SetWorkPoolSize(size uint32) {
 	s.Handles.SetWorkPoolSize(size)
 }
 
+//设置工作池分发策略，affinity保证同一连接的消息按顺序处理
+func (s *Server) SetDispatch(dispatch string) {
+	s.Handles.SetDispatch(dispatch)
+}
+
+//设置任务队列长度、队列满时的处理策略 block|drop|busy 及busy策略回复的数据
+func (s *Server) SetTaskQueue(size int, policy string, busyReply []byte) {
+	s.Handles.SetTaskQueue(size, policy, busyReply)
+}
+
+//设置工作池弹性扩容的最大工作协程数、扩容的排队延迟阈值及空闲退出时间，affinity分发策略下不生效
+func (s *Server) SetWorkPoolScale(max uint32, latency time.Duration, idleTimeout time.Duration) {
+	s.Handles.SetWorkPoolScale(max, latency, idleTimeout)
+}
+
+//设置请求因任务队列满被拒绝时的回调
+func (s *Server) OnReject(fn RejectHandler) {
+	s.Handles.OnReject(fn)
+}
+
+//设置处理方法panic时的回调，工作协程恢复后继续处理后续请求
+func (s *Server) OnPanic(fn PanicHandler) {
+	s.Handles.OnPanic(fn)
+}
+
+//设置处理方法错误的统一回调，WrapE包装的处理方法返回的错误及ctx.Error记录的错误在调用链结束后回调
+func (s *Server) OnError(fn ErrorHandler) {
+	s.Handles.OnError(fn)
+}
+
+//设置请求处理的默认超时时间，路由可单独设置
+func (s *Server) SetHandlerTimeout(timeout time.Duration) {
+	s.Handles.SetHandlerTimeout(timeout)
+}
+
+//设置请求处理超时时的回调，在超时时触发，处理方法可能仍在执行
+func (s *Server) OnTimeout(fn TimeoutHandler) {
+	s.Handles.OnTimeout(fn)
+}
+
 func (s *Server) GetManager() IManager {
 	return s.manager
 }
 
-func (s *Server) GetRid() *uint32 {
-	return s.rids
+//设置clientId生成器
+func (s *Server) SetClientIdGenerator(generator IClientIdGenerator) {
+	s.clientIdGenerator = generator
+}
+
+func (s *Server) GetClientIdGenerator() IClientIdGenerator {
+	return s.clientIdGenerator
+}
+
+//生成请求id，全局单调递增
+func (s *Server) NextRid() uint64 {
+	return atomic.AddUint64(&s.rid, 1)
 }
 
 func (s *Server) OverLoad(o overloadHandler) {
@@ -274,11 +539,13 @@ func (s *Server) OnStart(hook hookHandler) {
 }
 func (s *Server) runOnStart(c IConnection) {
 	if s.onStart != nil {
-		s.onStart(c)
+		s.Handles.safeRunEvent(c, func() {
+			s.onStart(c)
+		})
 	}
-	if s.Handles.eventHandle.OnConnect != nil {
+	s.Handles.safeRunEvent(c, func() {
 		s.Handles.eventHandle.OnConnect(c, AddressToClientId(c))
-	}
+	})
 }
 
 //连接断开hook
@@ -287,10 +554,22 @@ func (s *Server) OnStop(c hookHandler) {
 }
 func (s *Server) runOnStop(c IConnection) {
 	if s.onStop != nil {
-		s.onStop(c)
+		s.Handles.safeRunEvent(c, func() {
+			s.onStop(c)
+		})
 	}
-	if s.Handles.eventHandle.OnClose != nil {
+	//OnClose中仍可以获取uid，Manager.Del时解除绑定
+	s.Handles.safeRunEvent(c, func() {
 		s.Handles.eventHandle.OnClose(c, AddressToClientId(c))
+	})
+}
+
+//连接空闲回调
+func (s *Server) runOnIdle(c IConnection, state IdleState) {
+	if ie, ok := s.Handles.eventHandle.(IIdleEvent); ok {
+		s.Handles.safeRunEvent(c, func() {
+			ie.OnIdle(c, AddressToClientId(c), state)
+		})
 	}
 }
 
@@ -298,8 +577,88 @@ func (s *Server) SetEventHandle(event IEvent) {
 	s.Handles.SetEventHandle(event)
 }
 
+//设置协议解析，兼容字符串方式的IPack
 func (s *Server) SetProtoPack(proto IPack) {
-	s.protoPack = proto
+	if proto == nil {
+		s.codec = nil
+		return
+	}
+	s.codec = NewPackCodec(proto)
+}
+
+//设置协议编解码
+func (s *Server) SetCodec(codec ICodec) {
+	s.codec = codec
+}
+
+//设置通讯字符集转换，nil表示原始字节透传
+func (s *Server) SetCharset(charset ICharset) {
+	s.charset = charset
+}
+
+//设置连接空闲时间，0表示不检测
+func (s *Server) SetIdleTimeout(read, write, all time.Duration) {
+	s.readIdleTimeout = read
+	s.writeIdleTimeout = write
+	s.allIdleTimeout = all
+}
+
+//设置内置心跳，未设置读空闲时间时使用默认读超时
+func (s *Server) SetHeartbeat(heartbeat *Heartbeat) {
+	s.heartbeat = heartbeat
+	if heartbeat != nil && s.readIdleTimeout == 0 {
+		s.readIdleTimeout = DEFAULT_READ_TIMEOUT
+	}
+}
+
+//设置连接发送队列大小、队列满时的处理策略及block策略下的发送超时时间
+func (s *Server) SetSendQueue(size int, policy string, timeout time.Duration) {
+	switch policy {
+	case "", SendBlock, SendDropNewest, SendDropOldest, SendDisconnect:
+	default:
+		Log.Error("unsupported send policy %s, use %s", policy, SendBlock)
+		policy = SendBlock
+	}
+	s.sendQueueSize = size
+	s.sendPolicy = policy
+	s.sendTimeout = timeout
+}
+
+//启用TLS，加载证书失败返回错误
+func (s *Server) SetTLS(options *TLSOptions) error {
+	reloader, err := newTLSReloader(options)
+	if err != nil {
+		return err
+	}
+	s.tls = reloader
+	return nil
+}
+
+//设置PROXY协议解析，需在Run之前设置
+func (s *Server) SetProxyProtocol(options *ProxyProtocolOptions) error {
+	proxy, err := newProxyProtocol(options)
+	if err != nil {
+		return err
+	}
+	s.proxy = proxy
+	return nil
+}
+
+//设置WebSocket，需在Run之前设置，nil使用默认设置
+func (s *Server) SetWebSocket(options *WebSocketOptions) {
+	s.websocket = websocketOptions(options)
+}
+
+//重新加载TLS证书，已建立的连接不受影响
+func (s *Server) ReloadTLS() {
+	if s.tls != nil {
+		s.tls.reload()
+	}
+	for _, l := range s.listeners {
+		if l.tls != nil && l.tls != s.tls {
+			l.tls.reload()
+		}
+	}
 }
 
 //返回配置对象
@@ -381,27 +740,23 @@ func (s *Server) checkOp() (string, error) {
 	return "", nil
 }
 
-//连接端封装程clientId
+//获取连接的clientId
 func AddressToClientId(connection IConnection) string {
-	address := connection.GetConn().RemoteAddr().String()
-	str := hex.EncodeToString([]byte(address + ":" + strconv.Itoa(int(connection.GetID()))))
-	return str
+	return connection.GetClientId()
 }
 
-//通过clientId 解析成连接对象
-func clientIdToAddress(clientId string) (ip string, port uint32, connId uint32) {
-	hex_data, _ := hex.DecodeString(clientId)
-	connInfo := strings.Split(string(hex_data), ":")
-	ip = connInfo[0]
-	port = cast.ToUint32(connInfo[1])
-	connId = cast.ToUint32(connInfo[2])
-	return
+//通过clientId获取连接，clientId不合法或连接不存在返回错误
+func getConnection(request IRequest, clientId string) (IConnection, error) {
+	server := request.GetConnection().GetServer()
+	if err := server.GetClientIdGenerator().Validate(clientId); err != nil {
+		return nil, err
+	}
+	return server.GetManager().GetByClientId(clientId)
 }
 
 //给给定的客户端发送消息
 func SendToClient(request IRequest, clientId string, data []byte) error {
-	_, _, connId := clientIdToAddress(clientId)
-	c, err := request.GetConnection().GetServer().GetManager().Get(connId)
+	c, err := getConnection(request, clientId)
 	if err != nil {
 		Log.Error("获取链接信息失败:%s", err.Error())
 		return err
@@ -409,15 +764,159 @@ func SendToClient(request IRequest, clientId string, data []byte) error {
 	return c.Send(data)
 }
 
+//给所有客户端发送消息，exclude为排除的clientId，返回发送失败的clientId及错误
+func SendToAll(request IRequest, data []byte, exclude ...string) map[string]error {
+	excludes := make(map[string]struct{}, len(exclude))
+	for _, clientId := range exclude {
+		excludes[clientId] = struct{}{}
+	}
+	manager := request.GetConnection().GetServer().GetManager()
+	clientIdMap := make(map[uint32]string)
+	errs := manager.Broadcast(data, func(c IConnection) bool {
+		if _, ok := excludes[c.GetClientId()]; ok {
+			return false
+		}
+		clientIdMap[c.GetID()] = c.GetClientId()
+		return true
+	})
+	return toClientIdErrors(errs, clientIdMap)
+}
+
+//给多个客户端发送消息，返回发送失败的clientId及错误
+func SendToClients(request IRequest, clientIds []string, data []byte) map[string]error {
+	errs := make(map[string]error)
+	cons := make([]IConnection, 0, len(clientIds))
+	for _, clientId := range clientIds {
+		c, err := getConnection(request, clientId)
+		if err != nil {
+			errs[clientId] = err
+			continue
+		}
+		cons = append(cons, c)
+	}
+	for clientId, err := range sendToClientConnections(cons, data) {
+		errs[clientId] = err
+	}
+	return errs
+}
+
+//客户端加入分组
+func JoinGroup(request IRequest, clientId string, group string) error {
+	c, err := getConnection(request, clientId)
+	if err != nil {
+		return err
+	}
+	return c.GetServer().GetManager().JoinGroup(c.GetID(), group)
+}
+
+//客户端退出分组
+func LeaveGroup(request IRequest, clientId string, group string) error {
+	c, err := getConnection(request, clientId)
+	if err != nil {
+		return err
+	}
+	c.GetServer().GetManager().LeaveGroup(c.GetID(), group)
+	return nil
+}
+
+//给分组内的客户端发送消息，exclude为排除的clientId，返回发送失败的clientId及错误
+func SendToGroup(request IRequest, group string, data []byte, exclude ...string) map[string]error {
+	excludes := make(map[string]struct{}, len(exclude))
+	for _, clientId := range exclude {
+		excludes[clientId] = struct{}{}
+	}
+	cons := make([]IConnection, 0)
+	for _, c := range request.GetConnection().GetServer().GetManager().GroupMembers(group) {
+		if _, ok := excludes[c.GetClientId()]; !ok {
+			cons = append(cons, c)
+		}
+	}
+	return sendToClientConnections(cons, data)
+}
+
+//获取分组内的clientId
+func GroupMembers(request IRequest, group string) []string {
+	return toClientIds(request.GetConnection().GetServer().GetManager().GroupMembers(group))
+}
+
+//获取分组内的客户端数量
+func GroupCount(request IRequest, group string) int {
+	return request.GetConnection().GetServer().GetManager().GroupCount(group)
+}
+
+//客户端绑定uid，一个uid可以绑定多个客户端
+func BindUid(request IRequest, clientId string, uid string) error {
+	c, err := getConnection(request, clientId)
+	if err != nil {
+		return err
+	}
+	return c.GetServer().GetManager().BindUid(c.GetID(), uid)
+}
+
+//客户端解除绑定uid
+func UnbindUid(request IRequest, clientId string, uid string) error {
+	c, err := getConnection(request, clientId)
+	if err != nil {
+		return err
+	}
+	c.GetServer().GetManager().UnbindUid(c.GetID(), uid)
+	return nil
+}
+
+//给uid绑定的所有客户端发送消息，返回发送失败的clientId及错误
+func SendToUid(request IRequest, uid string, data []byte) map[string]error {
+	return sendToClientConnections(request.GetConnection().GetServer().GetManager().UidConnections(uid), data)
+}
+
+//uid是否有在线的客户端
+func IsUidOnline(request IRequest, uid string) bool {
+	return len(request.GetConnection().GetServer().GetManager().UidConnections(uid)) > 0
+}
+
+//获取uid绑定的clientId
+func GetClientIdsByUid(request IRequest, uid string) []string {
+	return toClientIds(request.GetConnection().GetServer().GetManager().UidConnections(uid))
+}
+
+//获取客户端绑定的uid，未绑定返回空字符串
+func GetUidByClientId(request IRequest, clientId string) string {
+	c, err := getConnection(request, clientId)
+	if err != nil {
+		return ""
+	}
+	uid, _ := c.GetServer().GetManager().GetUid(c.GetID())
+	return uid
+}
+
+//给多个连接发送消息，返回发送失败的clientId及错误
+func sendToClientConnections(cons []IConnection, data []byte) map[string]error {
+	clientIdMap := make(map[uint32]string, len(cons))
+	for _, c := range cons {
+		clientIdMap[c.GetID()] = c.GetClientId()
+	}
+	return toClientIdErrors(sendToConnections(cons, data), clientIdMap)
+}
+
+//连接id的错误转换为clientId的错误
+func toClientIdErrors(errs map[uint32]error, clientIdMap map[uint32]string) map[string]error {
+	res := make(map[string]error, len(errs))
+	for connId, err := range errs {
+		res[clientIdMap[connId]] = err
+	}
+	return res
+}
+
+func toClientIds(cons []IConnection) []string {
+	clientIds := make([]string, 0, len(cons))
+	for _, c := range cons {
+		clientIds = append(clientIds, c.GetClientId())
+	}
+	return clientIds
+}
+
 //踢掉一个连接并发送消息
 func CloseClient(request IRequest, clientId string, data []byte) error {
-	var connId uint32
-	if request.GetClientId() == clientId {
-		_, _, connId = clientIdToAddress(request.GetClientId())
-	} else {
-		_, _, connId = clientIdToAddress(clientId)
-	}
-	c, err := request.GetConnection().GetServer().GetManager().Get(connId)
+	c, err := getConnection(request, clientId)
 	if err != nil {
 		Log.Error("获取链接信息失败:%s", err.Error())
 		return err
@@ -434,8 +933,7 @@ func CloseClient(request IRequest, clientId string, data []byte) error {
 
 //是否有连接记录，是否在线
 func IsOnLine(request IRequest, clientId string) bool {
-	_, _, connId := clientIdToAddress(clientId)
-	_, err := request.GetConnection().GetServer().GetManager().Get(connId)
+	_, err := getConnection(request, clientId)
 	if err != nil {
 		Log.Error("查询是否在线记录失败:%s", err.Error())
 		return false
@@ -1,11 +1,13 @@
 package znets
 
 import (
+	"context"
 	"net"
+	"time"
 )
 
 type hookHandler func(c IConnection)
-type overloadHandler func(c *net.TCPConn)
+type overloadHandler func(c net.Conn)
 
 type IServer interface {
 	Run()
@@ -16,12 +18,24 @@ type IServer interface {
 
 	After(HandlerFunc)
 	Use(HandlerFunc)
-	Abort()
 
+	AddListener(options *ListenerOptions) error
 	SetWorkPoolSize(uint32)
-	GetRid() *uint32
+	SetDispatch(string)
+	SetTaskQueue(int, string, []byte)
+	SetWorkPoolScale(uint32, time.Duration, time.Duration)
+	OnReject(RejectHandler)
+	OnPanic(PanicHandler)
+	OnError(ErrorHandler)
+	SetHandlerTimeout(time.Duration)
+	OnTimeout(TimeoutHandler)
+	HandleTimeout(uint32, time.Duration, HandlerFunc, ...HandlerFunc)
+	Context() context.Context
+	NextRid() uint64
+	Stats() Stats
 
 	GetManager() IManager
+	GetClientIdGenerator() IClientIdGenerator
 	OverLoad(overloadHandler)
 	SetMaxCon(uint32)
 
@@ -31,5 +45,10 @@ type IServer interface {
 	OnStop(hookHandler)
 	runOnStop(IConnection)
 
+	runOnIdle(IConnection, IdleState)
+
 	SetEventHandle(IEvent)
+
+	Handle(uint32, HandlerFunc, ...HandlerFunc)
+	HandleGroup(uint32, uint32, ...HandlerFunc) *RouteGroup
 }
@@ -0,0 +1,352 @@
+package znets
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_LISTENER = "default" //Options中设置的监听名称
+
+//监听设置，一个server可添加多个监听，共用连接管理、工作池及IEvent
+type ListenerOptions struct {
+	Name              string                //监听名称，连接通过GetListener获取
+	Network           string                //传输协议 tcp|udp|ws|unix，默认tcp
+	IP                string                //为空时按IPVersion监听所有地址
+	Port              int                   //监听端口
+	IPVersion         string                //IP协议版本 tcp4|tcp6|tcp，默认tcp4
+	SocketPath        string                //unix方式的socket文件路径，以@开头时为abstract socket
+	SocketFileMode    os.FileMode           //unix方式的socket文件权限，0表示不修改
+	UDPSessionTimeout time.Duration         //UDP虚拟连接超时时间，默认60s
+	TLS               *TLSOptions           //TLS设置，nil表示不启用
+	ProxyProtocol     *ProxyProtocolOptions //PROXY协议设置，nil表示不启用
+	WebSocket         *WebSocketOptions     //WebSocket设置，ws方式时nil使用默认设置
+	Codec             ICodec                //协议编解码，nil使用server的设置
+	Charset           string                //通讯字符集，为空使用server的设置，ws方式默认none
+	Middlewares       []HandlerFunc         //该监听接入连接的请求额外执行的中间件，在全局中间件之后执行
+}
+
+//运行中的监听
+type serverListener struct {
+	name              string
+	network           string
+	ipVersion         string
+	address           string //ip:port或socket文件路径
+	socketFileMode    os.FileMode
+	udpSessionTimeout time.Duration
+	tls               *tlsReloader
+	proxy             *proxyProtocol
+	websocket         *WebSocketOptions
+	codec             ICodec
+	charset           ICharset
+	hasCharset        bool //是否单独设置了字符集
+
+	conn    *Listener
+	udpConn *UDPListener
+}
+
+//添加监听，需在Run之前添加
+func (s *Server) AddListener(options *ListenerOptions) error {
+	if options.Name == "" || options.Name == DEFAULT_LISTENER {
+		return fmt.Errorf("invalid listener name %q", options.Name)
+	}
+	for _, l := range s.listeners {
+		if l.name == options.Name {
+			return fmt.Errorf("listener %s already exists", options.Name)
+		}
+	}
+
+	network, err := checkNetwork(options.Network, options.SocketPath)
+	if err != nil {
+		return err
+	}
+	ipVersion, ip, err := checkIPVersion(options.IPVersion, options.IP)
+	if err != nil {
+		return err
+	}
+	l := &serverListener{
+		name:              options.Name,
+		network:           network,
+		ipVersion:         ipVersion,
+		address:           net.JoinHostPort(ip, strconv.Itoa(options.Port)),
+		socketFileMode:    options.SocketFileMode,
+		udpSessionTimeout: options.UDPSessionTimeout,
+		codec:             options.Codec,
+	}
+	if network == NetworkUnix {
+		l.address = options.SocketPath
+	}
+	if l.udpSessionTimeout <= 0 {
+		l.udpSessionTimeout = DEFAULT_READ_TIMEOUT
+	}
+	if network == NetworkWS {
+		l.websocket = websocketOptions(options.WebSocket)
+	}
+
+	charsetName := options.Charset
+	if charsetName == "" && network == NetworkWS {
+		charsetName = CharsetNone
+	}
+	if charsetName != "" {
+		if l.charset, err = GetCharset(charsetName); err != nil {
+			return err
+		}
+		l.hasCharset = true
+	}
+
+	if options.TLS != nil {
+		if l.tls, err = newTLSReloader(options.TLS); err != nil {
+			return err
+		}
+	}
+	if options.ProxyProtocol != nil {
+		if network == NetworkUDP {
+			return errors.New("PROXY协议仅支持流式连接")
+		}
+		if l.proxy, err = newProxyProtocol(options.ProxyProtocol); err != nil {
+			return err
+		}
+	}
+
+	s.Handles.UseListener(options.Name, options.Middlewares...)
+	s.listeners = append(s.listeners, l)
+	return nil
+}
+
+//Options中设置的监听
+func (s *Server) defaultListener() *serverListener {
+	l := &serverListener{
+		name:              DEFAULT_LISTENER,
+		network:           s.network,
+		ipVersion:         s.IPVersion,
+		address:           net.JoinHostPort(s.IP, strconv.Itoa(s.Port)),
+		socketFileMode:    s.socketFileMode,
+		udpSessionTimeout: s.udpSessionTimeout,
+		tls:               s.tls,
+		proxy:             s.proxy,
+		websocket:         s.websocket,
+	}
+	if s.network == NetworkUnix {
+		l.address = s.socketPath
+	}
+	return l
+}
+
+//检查传输协议
+func checkNetwork(network string, socketPath string) (string, error) {
+	switch network {
+	case NetworkTCP, NetworkUDP, NetworkWS:
+	case NetworkUnix:
+		if socketPath == "" {
+			return "", errors.New("unix方式需要设置SocketPath")
+		}
+	case "":
+		network = NetworkTCP
+	default:
+		return "", errors.New("不支持的传输协议：" + network)
+	}
+	return network, nil
+}
+
+//检查IP协议版本，ip为空时返回对应版本监听所有地址的ip
+func checkIPVersion(ipVersion string, ip string) (string, string, error) {
+	switch ipVersion {
+	case IPv4, IPv6, DualStack:
+	case "":
+		ipVersion = IPv4
+	default:
+		return "", "", errors.New("不支持的IP协议版本：" + ipVersion)
+	}
+	if ip == "" {
+		switch ipVersion {
+		case IPv4:
+			ip = "0.0.0.0"
+		case IPv6:
+			ip = "::"
+		}
+	}
+	return ipVersion, ip, nil
+}
+
+//开始监听
+func (l *serverListener) listen(s *Server) error {
+	var err error
+	switch l.network {
+	case NetworkUDP:
+		var addr *net.UDPAddr
+		network := strings.Replace(l.ipVersion, NetworkTCP, NetworkUDP, 1)
+		if addr, err = net.ResolveUDPAddr(network, l.address); err != nil {
+			return err
+		}
+		l.udpConn, err = ListenUDP(network, addr, s)
+	case NetworkUnix:
+		l.conn, err = ListenUnix(l.address, l.socketFileMode, s)
+	default:
+		var addr *net.TCPAddr
+		if addr, err = net.ResolveTCPAddr(l.ipVersion, l.address); err != nil {
+			return err
+		}
+		l.conn, err = ListenTCP(l.ipVersion, addr, s)
+	}
+	return err
+}
+
+//关闭监听
+func (l *serverListener) close() {
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	if l.udpConn != nil {
+		l.udpConn.Close()
+	}
+}
+
+//支持优雅重启的监听
+func (l *serverListener) graceListener() graceListener {
+	if l.udpConn != nil {
+		return l.udpConn
+	}
+	return l.conn
+}
+
+//循环接受连接
+func (l *serverListener) serve(s *Server) {
+	if l.network == NetworkUDP {
+		l.serveUDP(s)
+		return
+	}
+
+	//监听成功输出
+	Log.Info("Start server success..., listener %s listen on %s", l.name, l.conn.Addr().String())
+	//循环接受用户连接
+	for {
+		if s.exiting() {
+			l.conn.Close()
+			break
+		}
+		con, err := l.conn.Accept()
+		if err != nil {
+			Log.Error("Accept err:%s", err)
+			if strings.Contains(err.Error(), " use of closed network connection") {
+				Log.Info("连接已关闭")
+				break
+			}
+			continue
+		}
+
+		if s.manager.Num() >= int(s.maxConnections) {
+			if s.overload != nil {
+				s.overload(con)
+			}
+			con.Close()
+			l.conn.wg.Done()
+			continue
+		}
+
+		go l.serveConn(s, con, atomic.AddUint32(&s.cid, 1)-1)
+	}
+}
+
+//完成握手后创建连接并启动
+func (l *serverListener) serveConn(s *Server, con net.Conn, id uint32) {
+	//PROXY协议头在TLS握手之前
+	if l.proxy != nil {
+		pc, err := l.proxy.accept(con)
+		if err != nil {
+			Log.Error("proxy protocol err:%s, Addr = %s", err.Error(), con.RemoteAddr().String())
+			con.Close()
+			l.conn.wg.Done()
+			return
+		}
+		con = pc
+	}
+
+	var rw net.Conn = con
+	var tlsConn *tls.Conn
+	if l.tls != nil {
+		tlsConn = tls.Server(con, l.tls.tlsConfig())
+		tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+		if err := tlsConn.Handshake(); err != nil {
+			Log.Error("tls handshake err:%s, Addr = %s", err.Error(), con.RemoteAddr().String())
+			con.Close()
+			l.conn.wg.Done()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		rw = tlsConn
+	}
+	if l.network == NetworkWS {
+		ws, err := upgradeWebSocket(rw, l.websocket)
+		if err != nil {
+			Log.Error("websocket handshake err:%s, Addr = %s", err.Error(), con.RemoteAddr().String())
+			con.Close()
+			l.conn.wg.Done()
+			return
+		}
+		rw = ws
+	}
+
+	dealCon := newConnection(s, con, rw, tlsConn, id, s.Handles, l.conn.wg)
+	l.setupConn(s, dealCon)
+	dealCon.Start()
+}
+
+//读取数据报，每个远程地址作为一个虚拟连接
+func (l *serverListener) serveUDP(s *Server) {
+	Log.Info("Start udp server success..., listener %s listen on %s", l.name, l.udpConn.LocalAddr().String())
+	go l.udpConn.expire(l.udpSessionTimeout)
+
+	buff := make([]byte, readBufferSize)
+	for {
+		if s.exiting() {
+			l.udpConn.Close()
+			break
+		}
+		n, remote, err := l.udpConn.ReadFromUDP(buff)
+		if err != nil {
+			Log.Error("Read udp err:%s", err)
+			if strings.Contains(err.Error(), " use of closed network connection") {
+				Log.Info("连接已关闭")
+				break
+			}
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buff[:n])
+
+		session, isNew := l.udpConn.session(remote)
+		if isNew {
+			if s.manager.Num() >= int(s.maxConnections) {
+				session.Close()
+				continue
+			}
+			l.udpConn.wg.Add(1)
+			dealCon := newConnection(s, session, session, nil, atomic.AddUint32(&s.cid, 1)-1, s.Handles, l.udpConn.wg)
+			l.setupConn(s, dealCon)
+			dealCon.Start()
+		}
+		session.push(data)
+	}
+}
+
+//使用server及监听的设置初始化连接，需在Start之前调用，此时连接还未加入管理
+func (l *serverListener) setupConn(s *Server, dealCon *Connection) {
+	dealCon.listener = l.name
+	dealCon.SetCodec(s.codec)
+	if l.codec != nil {
+		dealCon.SetCodec(l.codec)
+	}
+	dealCon.SetCharset(s.charset)
+	if l.hasCharset {
+		dealCon.SetCharset(l.charset)
+	}
+	dealCon.SetIdleTimeout(s.readIdleTimeout, s.writeIdleTimeout, s.allIdleTimeout)
+	dealCon.SetHeartbeat(s.heartbeat)
+	dealCon.SetSendQueue(s.sendQueueSize, s.sendPolicy, s.sendTimeout)
+}
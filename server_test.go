@@ -0,0 +1,431 @@
+package znets
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//测试用事件，收到的消息原样返回
+type echoEvent struct{}
+
+func (e *echoEvent) OnMessage(request IRequest) {
+	request.GetConnection().Send(request.GetData())
+}
+func (e *echoEvent) OnConnect(c IConnection, clientId string) {}
+func (e *echoEvent) OnClose(c IConnection, clientId string)   {}
+func (e *echoEvent) OnWorkerStart()                           {}
+
+var (
+	testServerOnce sync.Once
+	testSrv        *Server
+	testAddr       string
+	testRunDone    = make(chan struct{})
+	testServerDir  string
+)
+
+//所有测试共用一个server，buildServ会设置包级Log，旧server的协程仍可能在读取，每个进程只构建一次
+func testServer(t *testing.T) (*Server, string) {
+	select {
+	case <-testRunDone:
+		t.Skip("test server stopped by TestStop")
+	default:
+	}
+	testServerOnce.Do(func() {
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		testServerDir, err = os.MkdirTemp("", "znets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Args = []string{"znets", "start"}
+		testSrv = NewServerWithOptions(&Options{
+			IP:          "127.0.0.1",
+			Port:        port,
+			WorkPool:    4,
+			Charset:     "none",
+			PidFilePath: filepath.Join(testServerDir, "pid"),
+		})
+		testSrv.SetEventHandle(&echoEvent{})
+		testSrv.SetCodec(NewLengthFieldPack(4))
+		testAddr = "127.0.0.1:" + strconv.Itoa(port)
+
+		go func() {
+			testSrv.Run()
+			close(testRunDone)
+		}()
+		waitFor(t, "server listen", func() bool {
+			c, err := net.Dial("tcp", testAddr)
+			if err != nil {
+				return false
+			}
+			c.Close()
+			return true
+		})
+	})
+	if testSrv == nil {
+		t.Fatal("test server not started")
+	}
+	//等待之前测试的连接全部关闭
+	waitFor(t, "previous connections closed", func() bool {
+		return testSrv.GetManager().Num() == 0
+	})
+	return testSrv, testAddr
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testServerDir != "" {
+		os.RemoveAll(testServerDir)
+	}
+	os.Exit(code)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//测试客户端，按LengthFieldPack编解码
+type testClient struct {
+	conn  net.Conn
+	codec ICodec
+	buf   []byte
+}
+
+func dialTest(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, codec: NewLengthFieldPack(4)}
+}
+
+func (c *testClient) send(data []byte) error {
+	frame, err := c.codec.Encode(&Message{Data: data, Length: uint32(len(data))}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+func (c *testClient) read() (IMessage, error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	tmp := make([]byte, 4096)
+	for {
+		msg, consumed, err := c.codec.Decode(c.buf)
+		if err != nil {
+			return nil, err
+		}
+		if consumed > 0 {
+			c.buf = c.buf[consumed:]
+			return msg, nil
+		}
+		n, err := c.conn.Read(tmp)
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, tmp[:n]...)
+	}
+}
+
+//连接后等待server端的连接加入管理
+func connectN(t *testing.T, s *Server, addr string, n int) ([]*testClient, []IConnection) {
+	t.Helper()
+	clients := make([]*testClient, n)
+	for i := range clients {
+		clients[i] = dialTest(t, addr)
+	}
+	var conns []IConnection
+	waitFor(t, "connections added", func() bool {
+		conns = conns[:0]
+		s.GetManager().Range(func(c IConnection) bool {
+			conns = append(conns, c)
+			return true
+		})
+		return len(conns) == n
+	})
+	return clients, conns
+}
+
+func closeClients(clients []*testClient) {
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	s, addr := testServer(t)
+	clients, conns := connectN(t, s, addr, 1)
+	defer closeClients(clients)
+
+	const senders, perSender = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if err := conns[0].Send([]byte(strconv.Itoa(i*perSender + j))); err != nil {
+					t.Errorf("send: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i := 0; i < senders*perSender; i++ {
+		msg, err := clients[0].read()
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		seen[string(msg.GetData())] = true
+	}
+	if len(seen) != senders*perSender {
+		t.Fatalf("got %d distinct messages, want %d", len(seen), senders*perSender)
+	}
+}
+
+func TestConcurrentEcho(t *testing.T) {
+	s, addr := testServer(t)
+	clients, _ := connectN(t, s, addr, 4)
+	defer closeClients(clients)
+
+	const perClient = 100
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *testClient) {
+			defer wg.Done()
+			go func() {
+				for i := 0; i < perClient; i++ {
+					c.send([]byte("ping"))
+				}
+			}()
+			for i := 0; i < perClient; i++ {
+				msg, err := c.read()
+				if err != nil {
+					t.Errorf("read %d: %v", i, err)
+					return
+				}
+				if string(msg.GetData()) != "ping" {
+					t.Errorf("got %q", msg.GetData())
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentBroadcast(t *testing.T) {
+	s, addr := testServer(t)
+	clients, _ := connectN(t, s, addr, 4)
+	defer closeClients(clients)
+
+	const broadcasters, perBroadcaster = 4, 20
+	var wg sync.WaitGroup
+	for i := 0; i < broadcasters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perBroadcaster; j++ {
+				//过滤方法中操作管理器不能死锁
+				failed := s.GetManager().Broadcast([]byte("hi"), func(c IConnection) bool {
+					s.GetManager().JoinGroup(c.GetID(), "all")
+					return true
+				})
+				for id, err := range failed {
+					t.Errorf("broadcast to %d: %v", id, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		for i := 0; i < broadcasters*perBroadcaster; i++ {
+			if _, err := c.read(); err != nil {
+				t.Fatalf("read %d: %v", i, err)
+			}
+		}
+	}
+	if n := s.GetManager().GroupCount("all"); n != len(clients) {
+		t.Fatalf("group count %d, want %d", n, len(clients))
+	}
+}
+
+func TestConcurrentStats(t *testing.T) {
+	s, addr := testServer(t)
+	clients, _ := connectN(t, s, addr, 2)
+	defer closeClients(clients)
+
+	before := s.Stats()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				st := s.Stats()
+				if st.InFlight < 0 {
+					t.Errorf("negative in flight: %+v", st)
+					return
+				}
+			}
+		}()
+	}
+
+	const perClient = 50
+	for _, c := range clients {
+		for i := 0; i < perClient; i++ {
+			c.send([]byte("x"))
+		}
+	}
+	for _, c := range clients {
+		for i := 0; i < perClient; i++ {
+			if _, err := c.read(); err != nil {
+				t.Fatalf("read %d: %v", i, err)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	total := uint64(len(clients) * perClient)
+	waitFor(t, "requests completed", func() bool {
+		st := s.Stats()
+		return st.InFlight == 0 && st.Completed-before.Completed == total
+	})
+	st := s.Stats()
+	if st.TotalRequests-before.TotalRequests != total {
+		t.Fatalf("total requests %d, want %d", st.TotalRequests-before.TotalRequests, total)
+	}
+	if st.Connections != len(clients) {
+		t.Fatalf("connections %d, want %d", st.Connections, len(clients))
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	s, addr := testServer(t)
+	clients, conns := connectN(t, s, addr, 4)
+	defer closeClients(clients)
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func(c IConnection) {
+				defer wg.Done()
+				c.Stop()
+			}(c)
+			go func(c IConnection) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					if err := c.Send([]byte("x")); err != nil && !errors.Is(err, ErrConnectionClosed) {
+						t.Errorf("send: %v", err)
+						return
+					}
+				}
+			}(c)
+		}
+	}
+	wg.Wait()
+
+	for _, c := range conns {
+		if err := c.Send([]byte("x")); !errors.Is(err, ErrConnectionClosed) {
+			t.Fatalf("send after close: %v", err)
+		}
+	}
+	waitFor(t, "connections removed", func() bool {
+		return s.GetManager().Num() == 0
+	})
+}
+
+//停止server，需放在最后执行
+func TestStop(t *testing.T) {
+	s, addr := testServer(t)
+	clients, _ := connectN(t, s, addr, 4)
+	defer closeClients(clients)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *testClient) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if c.send([]byte("x")) != nil {
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.GetManager().Broadcast([]byte("hi"), nil)
+			s.Stats()
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+	select {
+	case <-testRunDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+	close(done)
+	wg.Wait()
+
+	if n := s.GetManager().Num(); n != 0 {
+		t.Fatalf("%d connections left after Stop", n)
+	}
+	select {
+	case <-s.Context().Done():
+	default:
+		t.Fatal("server context not canceled")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("listener still accepting after Stop")
+	}
+}
@@ -0,0 +1,41 @@
+package znets
+
+import "sync/atomic"
+
+//服务运行统计
+type Stats struct {
+	Connections      int    //当前连接数
+	TotalConnections uint64 //累计接入的连接数
+	TotalRequests    uint64 //累计收到的请求数
+	InFlight         int64  //排队及处理中的请求数
+	Completed        uint64 //已处理完成的请求数
+	Rejected         uint64 //任务队列满被拒绝的请求数
+	Workers          int32  //当前工作协程数
+	QueuedTasks      int    //任务队列中排队的请求数
+}
+
+//获取服务运行统计，计数均为原子读取，可在任意协程调用
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Connections:      s.manager.Num(),
+		TotalConnections: uint64(atomic.LoadUint32(&s.cid)),
+		TotalRequests:    atomic.LoadUint64(&s.rid),
+	}
+	s.Handles.stats(&stats)
+	return stats
+}
+
+//填充工作池统计
+func (h *Handler) stats(stats *Stats) {
+	stats.InFlight = atomic.LoadInt64(&h.inflight)
+	stats.Completed = atomic.LoadUint64(&h.completed)
+	stats.Rejected = atomic.LoadUint64(&h.rejected)
+	stats.Workers = atomic.LoadInt32(&h.workers)
+	if atomic.LoadInt32(&h.started) == 0 {
+		return
+	}
+	for _, ch := range h.tasks {
+		stats.QueuedTasks += len(ch)
+	}
+	stats.QueuedTasks += len(h.shared)
+}
@@ -0,0 +1,31 @@
+package znets
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+type TimeoutHandler func(request IRequest, timeout time.Duration)
+
+//设置请求处理的默认超时时间，0表示不限制
+func (h *Handler) SetHandlerTimeout(timeout time.Duration) {
+	h.timeout = timeout
+}
+
+//设置请求处理超时的回调，在超时时由定时器协程触发，处理方法可能仍在执行
+func (h *Handler) OnTimeout(fn TimeoutHandler) {
+	h.onTimeout = fn
+}
+
+func (h *Handler) handleTimeout(request IRequest, timeout time.Duration) {
+	if h.onTimeout == nil {
+		Log.Warning("handler timeout %s, ConnID = %d, MsgId = %d", timeout, request.GetConnection().GetID(), request.GetID())
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			Log.Error("OnTimeout panic:%v, ConnID = %d\n%s", r, request.GetConnection().GetID(), debug.Stack())
+		}
+	}()
+	h.onTimeout(request, timeout)
+}
@@ -0,0 +1,151 @@
+package znets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+//TLS设置
+type TLSOptions struct {
+	CertFile       string        //证书文件
+	KeyFile        string        //私钥文件
+	ClientCAFile   string        //客户端CA证书，设置后要求并校验客户端证书(双向认证)
+	MinVersion     string        //最低版本 1.0|1.1|1.2|1.3，默认1.2
+	CipherSuites   []string      //加密套件名称，如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256，为空使用默认
+	ReloadInterval time.Duration //证书文件变更检测间隔，0表示只在收到SIGHUP信号时重新加载
+}
+
+//证书热加载，新连接使用最新加载的配置，已建立的连接不受影响
+type tlsReloader struct {
+	modTime int64 //已加载的证书文件最后修改时间(UnixNano)，watch与SIGHUP触发的load并发读写，原子操作
+	options *TLSOptions
+	config  atomic.Value //*tls.Config
+}
+
+func newTLSReloader(options *TLSOptions) (*tlsReloader, error) {
+	r := &tlsReloader{options: options}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	if options.ReloadInterval > 0 {
+		go r.watch()
+	}
+	return r, nil
+}
+
+//当前的TLS配置
+func (r *tlsReloader) tlsConfig() *tls.Config {
+	return r.config.Load().(*tls.Config)
+}
+
+//重新加载证书，失败时继续使用之前的配置
+func (r *tlsReloader) reload() {
+	if err := r.load(); err != nil {
+		Log.Error("reload tls certificate err:%s", err.Error())
+		return
+	}
+	Log.Info("reload tls certificate success")
+}
+
+func (r *tlsReloader) load() error {
+	modTime := r.lastModTime()
+	cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if config.MinVersion, err = parseTLSVersion(r.options.MinVersion); err != nil {
+		return err
+	}
+	if config.CipherSuites, err = parseCipherSuites(r.options.CipherSuites); err != nil {
+		return err
+	}
+	if r.options.ClientCAFile != "" {
+		caPem, err := os.ReadFile(r.options.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return errors.New("no valid certificate in " + r.options.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config.Store(config)
+	atomic.StoreInt64(&r.modTime, modTime)
+	return nil
+}
+
+//定时检测证书文件变更
+func (r *tlsReloader) watch() {
+	ticker := time.NewTicker(r.options.ReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if r.lastModTime() > atomic.LoadInt64(&r.modTime) {
+			r.reload()
+		}
+	}
+}
+
+//证书文件最后修改时间(UnixNano)，文件都不存在时返回0
+func (r *tlsReloader) lastModTime() int64 {
+	var last int64
+	for _, file := range []string{r.options.CertFile, r.options.KeyFile, r.options.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().UnixNano() > last {
+			last = info.ModTime().UnixNano()
+		}
+	}
+	return last
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls version %s", version)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,201 @@
+package znets
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const UDP_SESSION_QUEUE_SIZE = 256 //每个UDP会话待处理的数据报数量
+
+//按数据报读取的连接，每次读取一条完整的消息
+type messageReader interface {
+	ReadMessage() ([]byte, error)
+}
+
+func NewUDPListener(conn *net.UDPConn, server *Server) *UDPListener {
+	return &UDPListener{
+		UDPConn:  conn,
+		wg:       &sync.WaitGroup{},
+		server:   server,
+		sessions: make(map[string]*udpSession),
+		done:     make(chan struct{}),
+	}
+}
+
+//UDP监听，每个远程地址作为一个虚拟连接
+type UDPListener struct {
+	*net.UDPConn
+	wg       *sync.WaitGroup
+	server   *Server
+	sessions map[string]*udpSession
+	lock     sync.Mutex
+	done     chan struct{} //Close时关闭，通知会话过期检测退出
+	doneOnce sync.Once
+}
+
+func (l *UDPListener) GetWg() *sync.WaitGroup {
+	return l.wg
+}
+
+func (l *UDPListener) Wait() {
+	l.wg.Wait()
+}
+
+//关闭监听，并停止会话过期检测
+func (l *UDPListener) Close() error {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+	return l.UDPConn.Close()
+}
+
+func (l *UDPListener) GetFd() (uintptr, error) {
+	file, err := l.UDPConn.File()
+	if err != nil {
+		return 0, err
+	}
+	return file.Fd(), nil
+}
+
+//获取远程地址的会话，不存在时创建，返回是否新建
+func (l *UDPListener) session(addr *net.UDPAddr) (*udpSession, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := addr.String()
+	if s, ok := l.sessions[key]; ok {
+		return s, false
+	}
+	s := &udpSession{
+		listener:   l,
+		addr:       addr,
+		packets:    make(chan []byte, UDP_SESSION_QUEUE_SIZE),
+		closed:     make(chan struct{}),
+		lastActive: time.Now(),
+	}
+	l.sessions[key] = s
+	return s, true
+}
+
+func (l *UDPListener) remove(s *udpSession) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.sessions[s.addr.String()] == s {
+		delete(l.sessions, s.addr.String())
+	}
+}
+
+//定时关闭超过timeout没有收到数据的会话，监听关闭时退出
+func (l *UDPListener) expire(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		expired := make([]*udpSession, 0)
+		l.lock.Lock()
+		for _, s := range l.sessions {
+			if s.idle() >= timeout {
+				expired = append(expired, s)
+			}
+		}
+		l.lock.Unlock()
+
+		for _, s := range expired {
+			s.Close()
+		}
+	}
+}
+
+//UDP虚拟连接，实现net.Conn，写入时发送数据报到远程地址
+type udpSession struct {
+	listener   *UDPListener
+	addr       *net.UDPAddr
+	packets    chan []byte
+	closed     chan struct{}
+	closeOnce  sync.Once
+	lastActive time.Time
+	activeLock sync.Mutex
+}
+
+//收到数据报，会话队列满时丢弃
+func (s *udpSession) push(data []byte) {
+	s.activeLock.Lock()
+	s.lastActive = time.Now()
+	s.activeLock.Unlock()
+
+	select {
+	case s.packets <- data:
+	case <-s.closed:
+	default:
+		Log.Warning("udp session queue is full, drop packet from %s", s.addr.String())
+	}
+}
+
+func (s *udpSession) idle() time.Duration {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+
+	return time.Since(s.lastActive)
+}
+
+func (s *udpSession) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-s.packets:
+		return data, nil
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+func (s *udpSession) Read(b []byte) (int, error) {
+	data, err := s.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, data), nil
+}
+
+func (s *udpSession) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return s.listener.WriteToUDP(b, s.addr)
+}
+
+func (s *udpSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.listener.remove(s)
+	})
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.listener.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
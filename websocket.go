@@ -0,0 +1,471 @@
+package znets
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	WS_HANDSHAKE_TIMEOUT        = 10 * time.Second
+	DEFAULT_WS_MAX_MESSAGE_SIZE = 1 << 20 //默认最大消息长度
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+//WebSocket关闭码
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupported     = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseMessageTooBig   = 1009
+	WSCloseInternalError   = 1011
+)
+
+var (
+	ErrWebSocketHandshake = errors.New("websocket handshake failed")
+	ErrWebSocketProtocol  = errors.New("websocket protocol error")
+	ErrWebSocketTooBig    = errors.New("websocket message too big")
+)
+
+//deflate尾部，压缩时去掉，解压时补上
+var wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+//WebSocket设置
+type WebSocketOptions struct {
+	Path           string        //握手路径，默认"/"
+	Text           bool          //发送text帧，默认binary帧
+	Compress       bool          //启用permessage-deflate压缩，不保留压缩上下文
+	MaxMessageSize int           //最大消息长度，默认1M
+	PingInterval   time.Duration //服务端发送ping的间隔，0表示不发送
+}
+
+//设置默认值
+func websocketOptions(options *WebSocketOptions) *WebSocketOptions {
+	if options == nil {
+		options = &WebSocketOptions{}
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	return options
+}
+
+//WebSocket连接，每条完整的消息作为一次读取，每次写入作为一帧
+type wsConn struct {
+	net.Conn
+	br       *bufio.Reader
+	request  *http.Request
+	options  *WebSocketOptions
+	compress bool //是否协商了压缩
+	pending  []byte
+
+	writeLock sync.Mutex
+	closeSent bool
+	closeOnce sync.Once
+	exitChan  chan struct{}
+}
+
+//完成WebSocket握手，失败时回复400
+func upgradeWebSocket(conn net.Conn, options *WebSocketOptions) (*wsConn, error) {
+	conn.SetDeadline(time.Now().Add(WS_HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	br := bufio.NewReaderSize(conn, 4096)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet:
+		err = fmt.Errorf("%w: method %s", ErrWebSocketHandshake, req.Method)
+	case options.Path != "" && req.URL.Path != options.Path:
+		err = fmt.Errorf("%w: path %s", ErrWebSocketHandshake, req.URL.Path)
+	case !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket"):
+		err = fmt.Errorf("%w: not a websocket upgrade", ErrWebSocketHandshake)
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		err = fmt.Errorf("%w: unsupported version", ErrWebSocketHandshake)
+	case key == "":
+		err = fmt.Errorf("%w: missing key", ErrWebSocketHandshake)
+	}
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nSec-WebSocket-Version: 13\r\nConnection: close\r\n\r\n"))
+		return nil, err
+	}
+
+	c := &wsConn{
+		Conn:     conn,
+		br:       br,
+		request:  req,
+		options:  options,
+		exitChan: make(chan struct{}),
+	}
+
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n")
+	if options.Compress && acceptDeflate(req.Header) {
+		c.compress = true
+		resp.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	resp.WriteString("\r\n")
+	if _, err := conn.Write(resp.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if options.PingInterval > 0 {
+		go c.keepalive()
+	}
+	return c, nil
+}
+
+//读取一条完整的消息，控制帧返回空数据
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	var opcode byte
+	var compressed bool
+	for {
+		fin, rsv1, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case wsOpPing, wsOpPong:
+			if op == wsOpPing {
+				c.writeFrame(wsOpPong, payload, false)
+			}
+			//分片消息中间的控制帧不打断消息
+			if opcode != 0 {
+				continue
+			}
+			return []byte{}, nil
+		case wsOpClose:
+			code := WSCloseNoStatus
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.sendClose(code)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if opcode != 0 {
+				return nil, c.fail(WSCloseProtocolError, ErrWebSocketProtocol)
+			}
+			opcode = op
+			compressed = rsv1
+		case wsOpContinuation:
+			if opcode == 0 || rsv1 {
+				return nil, c.fail(WSCloseProtocolError, ErrWebSocketProtocol)
+			}
+		}
+
+		if len(message)+len(payload) > c.maxMessageSize() {
+			return nil, c.fail(WSCloseMessageTooBig, ErrWebSocketTooBig)
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+
+		if compressed {
+			if message, err = c.inflate(message); err != nil {
+				return nil, c.fail(WSCloseMessageTooBig, err)
+			}
+		}
+		if opcode == wsOpText && !utf8.Valid(message) {
+			return nil, c.fail(WSCloseInvalidPayload, ErrWebSocketProtocol)
+		}
+		return message, nil
+	}
+}
+
+//读取一帧，客户端发送的帧必须带掩码
+func (c *wsConn) readFrame() (fin bool, rsv1 bool, opcode byte, payload []byte, err error) {
+	var header [8]byte
+	if _, err = io.ReadFull(c.br, header[:2]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	rsv1 = header[0]&0x40 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	isControl := opcode >= wsOpClose
+	switch {
+	case header[0]&0x30 != 0, rsv1 && (!c.compress || isControl), !masked:
+		err = c.fail(WSCloseProtocolError, ErrWebSocketProtocol)
+		return
+	case opcode > wsOpBinary && !isControl, opcode > wsOpPong:
+		err = c.fail(WSCloseProtocolError, ErrWebSocketProtocol)
+		return
+	case isControl && (!fin || length > 125):
+		err = c.fail(WSCloseProtocolError, ErrWebSocketProtocol)
+		return
+	}
+
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.br, header[:2]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, header[:8]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+	}
+	if length > uint64(c.maxMessageSize()) {
+		err = c.fail(WSCloseMessageTooBig, ErrWebSocketTooBig)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+//按net.Conn方式读取，一次读取可能只返回消息的一部分
+func (c *wsConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		data, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = data
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+//写入一条消息，每次写入作为一帧
+func (c *wsConn) Write(b []byte) (int, error) {
+	opcode := byte(wsOpBinary)
+	if c.options.Text {
+		opcode = wsOpText
+	}
+
+	payload := b
+	if c.compress {
+		deflated, err := deflate(b)
+		if err != nil {
+			return 0, err
+		}
+		payload = deflated
+	}
+	if err := c.writeFrame(opcode, payload, c.compress); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+//发送close帧后关闭连接
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.exitChan)
+	})
+	c.sendClose(WSCloseNormal)
+	return c.Conn.Close()
+}
+
+//写入一帧，服务端发送的帧不带掩码
+func (c *wsConn) writeFrame(opcode byte, payload []byte, rsv1 bool) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return ErrConnectionClosed
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, len(payload)+10)
+	b0 := 0x80 | opcode
+	if rsv1 {
+		b0 |= 0x40
+	}
+	frame = append(frame, b0)
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+//发送close帧，只发送一次
+func (c *wsConn) sendClose(code int) {
+	var payload []byte
+	if code != WSCloseNoStatus {
+		payload = make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, uint16(code))
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsOpClose, payload, false)
+}
+
+//协议错误时发送对应的关闭码
+func (c *wsConn) fail(code int, err error) error {
+	c.sendClose(code)
+	return err
+}
+
+//定时发送ping，连接关闭时退出
+func (c *wsConn) keepalive() {
+	ticker := time.NewTicker(c.options.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writeFrame(wsOpPing, nil, false); err != nil {
+				return
+			}
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+func (c *wsConn) maxMessageSize() int {
+	if c.options.MaxMessageSize <= 0 {
+		return DEFAULT_WS_MAX_MESSAGE_SIZE
+	}
+	return c.options.MaxMessageSize
+}
+
+//解压消息，限制解压后的长度
+func (c *wsConn) inflate(data []byte) ([]byte, error) {
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(wsDeflateTail)))
+	defer reader.Close()
+
+	limit := c.maxMessageSize()
+	message, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if len(message) > limit {
+		return nil, ErrWebSocketTooBig
+	}
+	return message, nil
+}
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+//压缩消息，去掉deflate尾部
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), wsDeflateTail), nil
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//请求头是否包含指定的值，逗号分隔，不区分大小写
+func headerContains(header http.Header, name string, value string) bool {
+	for _, line := range header.Values(name) {
+		for _, token := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//客户端是否提供了可接受的permessage-deflate参数，服务端固定使用最大窗口
+func acceptDeflate(header http.Header) bool {
+	for _, line := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(line, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			accept := true
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if name == "server_max_window_bits" && strings.Trim(value, "\"") != "15" {
+					accept = false
+				}
+			}
+			if accept {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//获取WebSocket连接的握手请求，可读取路径参数及请求头，非WebSocket连接返回false
+func GetWebSocketRequest(c IConnection) (*http.Request, bool) {
+	con, ok := c.(*Connection)
+	if !ok {
+		return nil, false
+	}
+	ws, ok := con.rw.(*wsConn)
+	if !ok {
+		return nil, false
+	}
+	return ws.request, true
+}
@@ -0,0 +1,522 @@
+package znets
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const wsTestKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+//客户端收到的一帧
+type wsTestFrame struct {
+	b0      byte
+	payload []byte
+}
+
+//管道另一端的测试客户端
+type wsTestClient struct {
+	conn   net.Conn
+	resp   *http.Response
+	frames chan wsTestFrame
+}
+
+func wsTestHeader() http.Header {
+	return http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"keep-alive, Upgrade"},
+		"Sec-Websocket-Key":     {wsTestKey},
+		"Sec-Websocket-Version": {"13"},
+	}
+}
+
+//在net.Pipe上完成握手，客户端在单独的协程中读取响应及服务端发送的帧
+func dialWebSocket(t *testing.T, options *WebSocketOptions, method string, path string, header http.Header) (*wsConn, *wsTestClient, error) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	c := &wsTestClient{conn: client, frames: make(chan wsTestFrame, 16)}
+	respChan := make(chan *http.Response, 1)
+	go func() {
+		defer close(c.frames)
+		req := &http.Request{Method: method, URL: &url.URL{Path: path}, Host: "example.com", Header: header}
+		if err := req.Write(client); err != nil {
+			respChan <- nil
+			return
+		}
+		br := bufio.NewReader(client)
+		resp, err := http.ReadResponse(br, nil)
+		respChan <- resp
+		if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+			return
+		}
+		for {
+			frame, err := readTestFrame(br)
+			if err != nil {
+				return
+			}
+			c.frames <- frame
+		}
+	}()
+
+	ws, err := upgradeWebSocket(server, websocketOptions(options))
+	select {
+	case c.resp = <-respChan:
+	case <-time.After(time.Second):
+		t.Fatal("no handshake response")
+	}
+	return ws, c, err
+}
+
+//读取服务端发送的帧，服务端的帧不带掩码
+func readTestFrame(br *bufio.Reader) (wsTestFrame, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(br, header[:2]); err != nil {
+		return wsTestFrame{}, err
+	}
+	b0 := header[0]
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(br, header[:2]); err != nil {
+			return wsTestFrame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err := io.ReadFull(br, header[:8]); err != nil {
+			return wsTestFrame{}, err
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+	}
+	frame := wsTestFrame{b0: b0, payload: make([]byte, length)}
+	_, err := io.ReadFull(br, frame.payload)
+	return frame, err
+}
+
+//构造客户端发送的帧，b0为FIN、RSV及opcode
+func clientFrame(b0 byte, payload []byte) []byte {
+	return wsFrame(b0, payload, true)
+}
+
+func wsFrame(b0 byte, payload []byte, masked bool) []byte {
+	frame := []byte{b0}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func closePayload(code int) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return payload
+}
+
+func concatFrames(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+//客户端压缩的消息，去掉deflate尾部
+func clientDeflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), wsDeflateTail)
+}
+
+func (c *wsTestClient) nextFrame(t *testing.T) wsTestFrame {
+	t.Helper()
+	select {
+	case frame, ok := <-c.frames:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return frame
+	case <-time.After(time.Second):
+		t.Fatal("no frame from server")
+	}
+	return wsTestFrame{}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		header func(http.Header)
+		ok     bool
+	}{
+		{name: "ok", method: http.MethodGet, path: "/ws", ok: true},
+		{name: "case insensitive tokens", method: http.MethodGet, path: "/ws", header: func(h http.Header) {
+			h.Set("Upgrade", "WebSocket")
+			h.Set("Connection", "UPGRADE")
+		}, ok: true},
+		{name: "bad method", method: http.MethodPost, path: "/ws"},
+		{name: "bad path", method: http.MethodGet, path: "/other"},
+		{name: "no upgrade", method: http.MethodGet, path: "/ws", header: func(h http.Header) { h.Del("Upgrade") }},
+		{name: "no connection upgrade", method: http.MethodGet, path: "/ws", header: func(h http.Header) { h.Set("Connection", "keep-alive") }},
+		{name: "bad version", method: http.MethodGet, path: "/ws", header: func(h http.Header) { h.Set("Sec-WebSocket-Version", "8") }},
+		{name: "no key", method: http.MethodGet, path: "/ws", header: func(h http.Header) { h.Del("Sec-WebSocket-Key") }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := wsTestHeader()
+			if c.header != nil {
+				c.header(header)
+			}
+			ws, client, err := dialWebSocket(t, &WebSocketOptions{Path: "/ws"}, c.method, c.path, header)
+			if !c.ok {
+				if !errors.Is(err, ErrWebSocketHandshake) {
+					t.Fatalf("err %v, want %v", err, ErrWebSocketHandshake)
+				}
+				if client.resp == nil || client.resp.StatusCode != http.StatusBadRequest {
+					t.Fatalf("response %v, want 400", client.resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("upgrade: %v", err)
+			}
+			if client.resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("status %d, want 101", client.resp.StatusCode)
+			}
+			//RFC 6455 1.3中的示例
+			if accept := client.resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Fatalf("accept %q", accept)
+			}
+			if ws.request.URL.Path != "/ws" {
+				t.Fatalf("request path %s", ws.request.URL.Path)
+			}
+		})
+	}
+}
+
+func TestWebSocketNegotiateDeflate(t *testing.T) {
+	cases := []struct {
+		name     string
+		compress bool
+		offer    string
+		want     bool
+	}{
+		{name: "offer", compress: true, offer: "permessage-deflate; client_max_window_bits", want: true},
+		{name: "disabled", compress: false, offer: "permessage-deflate"},
+		{name: "no offer", compress: true},
+		{name: "small server window", compress: true, offer: "permessage-deflate; server_max_window_bits=10"},
+		{name: "second offer", compress: true, offer: "permessage-deflate; server_max_window_bits=10, permessage-deflate", want: true},
+		{name: "other extension", compress: true, offer: "x-webkit-deflate-frame"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := wsTestHeader()
+			if c.offer != "" {
+				header.Set("Sec-WebSocket-Extensions", c.offer)
+			}
+			ws, client, err := dialWebSocket(t, &WebSocketOptions{Compress: c.compress}, http.MethodGet, "/", header)
+			if err != nil {
+				t.Fatalf("upgrade: %v", err)
+			}
+			ext := client.resp.Header.Get("Sec-WebSocket-Extensions")
+			if ws.compress != c.want || (ext != "") != c.want {
+				t.Fatalf("compress %v extensions %q, want %v", ws.compress, ext, c.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketReadMessage(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 200)
+	cases := []struct {
+		name     string
+		maxSize  int
+		compress bool
+		data     []byte
+		want     []byte
+		err      error
+		replies  []wsTestFrame //服务端回复的帧
+	}{
+		{name: "binary", data: clientFrame(0x82, []byte("hello")), want: []byte("hello")},
+		{name: "text", data: clientFrame(0x81, []byte("你好")), want: []byte("你好")},
+		{name: "extended length", data: clientFrame(0x82, big), want: big},
+		{
+			name: "fragmented",
+			data: concatFrames(clientFrame(0x01, []byte("hel")), clientFrame(0x00, []byte("lo ")), clientFrame(0x80, []byte("world"))),
+			want: []byte("hello world"),
+		},
+		{
+			name:    "ping inside fragmented",
+			data:    concatFrames(clientFrame(0x02, []byte("ab")), clientFrame(0x89, []byte("p")), clientFrame(0x80, []byte("cd"))),
+			want:    []byte("abcd"),
+			replies: []wsTestFrame{{b0: 0x8A, payload: []byte("p")}},
+		},
+		{
+			name:    "ping",
+			data:    clientFrame(0x89, []byte("p")),
+			want:    []byte{},
+			replies: []wsTestFrame{{b0: 0x8A, payload: []byte("p")}},
+		},
+		{name: "pong", data: clientFrame(0x8A, nil), want: []byte{}},
+		{
+			name:    "close",
+			data:    clientFrame(0x88, closePayload(WSCloseGoingAway)),
+			err:     io.EOF,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseGoingAway)}},
+		},
+		{
+			name:    "close without code",
+			data:    clientFrame(0x88, nil),
+			err:     io.EOF,
+			replies: []wsTestFrame{{b0: 0x88, payload: []byte{}}},
+		},
+		{
+			name:    "too big",
+			maxSize: 8,
+			data:    clientFrame(0x82, []byte("123456789")),
+			err:     ErrWebSocketTooBig,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseMessageTooBig)}},
+		},
+		{
+			name:    "too big fragmented",
+			maxSize: 8,
+			data:    concatFrames(clientFrame(0x02, []byte("12345")), clientFrame(0x80, []byte("67890"))),
+			err:     ErrWebSocketTooBig,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseMessageTooBig)}},
+		},
+		{
+			name:    "too big 64 bit length",
+			data:    []byte{0x82, 0x80 | 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			err:     ErrWebSocketTooBig,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseMessageTooBig)}},
+		},
+		{
+			name:    "unmasked",
+			data:    wsFrame(0x82, []byte("hello"), false),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "bad utf8 text",
+			data:    clientFrame(0x81, []byte{0xff, 0xfe}),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseInvalidPayload)}},
+		},
+		{
+			name:    "bad utf8 fragmented text",
+			data:    concatFrames(clientFrame(0x01, []byte{0xe4, 0xbd}), clientFrame(0x80, []byte{0xa0, 0xff})),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseInvalidPayload)}},
+		},
+		{
+			name: "utf8 split across fragments",
+			data: concatFrames(clientFrame(0x01, []byte{0xe4, 0xbd}), clientFrame(0x80, []byte{0xa0})),
+			want: []byte("你"),
+		},
+		{
+			name:    "continuation without start",
+			data:    clientFrame(0x80, []byte("x")),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "data frame inside fragmented",
+			data:    concatFrames(clientFrame(0x02, []byte("ab")), clientFrame(0x82, []byte("cd"))),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "fragmented ping",
+			data:    clientFrame(0x09, []byte("p")),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "long ping",
+			data:    clientFrame(0x89, big[:126]),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "reserved opcode",
+			data:    clientFrame(0x83, []byte("x")),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "rsv1 without compression",
+			data:    clientFrame(0xC2, []byte("x")),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:     "rsv1 on control frame",
+			compress: true,
+			data:     clientFrame(0xC9, nil),
+			err:      ErrWebSocketProtocol,
+			replies:  []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+		{
+			name:    "rsv2",
+			data:    clientFrame(0xA2, []byte("x")),
+			err:     ErrWebSocketProtocol,
+			replies: []wsTestFrame{{b0: 0x88, payload: closePayload(WSCloseProtocolError)}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := wsTestHeader()
+			header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+			ws, client, err := dialWebSocket(t, &WebSocketOptions{MaxMessageSize: c.maxSize, Compress: c.compress}, http.MethodGet, "/", header)
+			if err != nil {
+				t.Fatalf("upgrade: %v", err)
+			}
+			go client.conn.Write(c.data)
+
+			msg, err := ws.ReadMessage()
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err %v, want %v", err, c.err)
+				}
+			} else if err != nil {
+				t.Fatalf("read: %v", err)
+			} else if !bytes.Equal(msg, c.want) || msg == nil {
+				t.Fatalf("message %q, want %q", msg, c.want)
+			}
+
+			for _, want := range c.replies {
+				frame := client.nextFrame(t)
+				if frame.b0 != want.b0 || !bytes.Equal(frame.payload, want.payload) {
+					t.Fatalf("reply %#x %v, want %#x %v", frame.b0, frame.payload, want.b0, want.payload)
+				}
+			}
+		})
+	}
+}
+
+func TestWebSocketCompressedRoundTrip(t *testing.T) {
+	header := wsTestHeader()
+	header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	ws, client, err := dialWebSocket(t, &WebSocketOptions{Compress: true, Text: true, MaxMessageSize: 4096}, http.MethodGet, "/", header)
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if !strings.Contains(client.resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatal("permessage-deflate not negotiated")
+	}
+
+	text := []byte(strings.Repeat("hello websocket ", 100))
+	compressed := clientDeflate(t, text)
+
+	//单帧压缩消息
+	go client.conn.Write(clientFrame(0xC1, compressed))
+	msg, err := ws.ReadMessage()
+	if err != nil || !bytes.Equal(msg, text) {
+		t.Fatalf("read %q err %v", msg, err)
+	}
+
+	//分片的压缩消息只有第一帧设置RSV1
+	half := len(compressed) / 2
+	go client.conn.Write(concatFrames(clientFrame(0x41, compressed[:half]), clientFrame(0x80, compressed[half:])))
+	msg, err = ws.ReadMessage()
+	if err != nil || !bytes.Equal(msg, text) {
+		t.Fatalf("read fragmented %q err %v", msg, err)
+	}
+
+	//未压缩的消息不设置RSV1
+	go client.conn.Write(clientFrame(0x81, []byte("plain")))
+	msg, err = ws.ReadMessage()
+	if err != nil || string(msg) != "plain" {
+		t.Fatalf("read plain %q err %v", msg, err)
+	}
+
+	//服务端发送压缩的text帧
+	if _, err := ws.Write(text); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	frame := client.nextFrame(t)
+	if frame.b0 != 0xC1 {
+		t.Fatalf("frame header %#x, want 0xc1", frame.b0)
+	}
+	if len(frame.payload) >= len(text) {
+		t.Fatalf("payload not compressed, %d bytes", len(frame.payload))
+	}
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(frame.payload), bytes.NewReader(wsDeflateTail)))
+	inflated, err := io.ReadAll(reader)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatalf("inflate: %v", err)
+	}
+	if !bytes.Equal(inflated, text) {
+		t.Fatalf("inflated %q", inflated)
+	}
+
+	//解压后超过最大消息长度
+	go client.conn.Write(clientFrame(0xC2, clientDeflate(t, make([]byte, 8192))))
+	if _, err := ws.ReadMessage(); !errors.Is(err, ErrWebSocketTooBig) {
+		t.Fatalf("err %v, want %v", err, ErrWebSocketTooBig)
+	}
+	frame = client.nextFrame(t)
+	if frame.b0 != 0x88 || !bytes.Equal(frame.payload, closePayload(WSCloseMessageTooBig)) {
+		t.Fatalf("reply %#x %v, want close 1009", frame.b0, frame.payload)
+	}
+}
+
+func TestWebSocketWrite(t *testing.T) {
+	ws, client, err := dialWebSocket(t, nil, http.MethodGet, "/", wsTestHeader())
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	big := bytes.Repeat([]byte("x"), 70000)
+	for _, data := range [][]byte{[]byte("hello"), big[:300], big} {
+		if _, err := ws.Write(data); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		frame := client.nextFrame(t)
+		if frame.b0 != 0x82 || !bytes.Equal(frame.payload, data) {
+			t.Fatalf("frame %#x with %d bytes, want binary %d bytes", frame.b0, len(frame.payload), len(data))
+		}
+	}
+
+	go ws.Close()
+	frame := client.nextFrame(t)
+	if frame.b0 != 0x88 || !bytes.Equal(frame.payload, closePayload(WSCloseNormal)) {
+		t.Fatalf("close frame %#x %v", frame.b0, frame.payload)
+	}
+	if _, err := ws.Write([]byte("x")); err == nil {
+		t.Fatal("write after close succeeded")
+	}
+}
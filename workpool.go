@@ -0,0 +1,157 @@
+package znets
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//任务队列满时的处理策略
+const (
+	RejectBlock = "block" //阻塞等待，读取协程暂停读取该连接
+	RejectDrop  = "drop"  //丢弃请求并回调OnReject
+	RejectBusy  = "busy"  //丢弃请求，通过协议编码回复BusyReply并回调OnReject
+
+	DEFAULT_TASK_QUEUE_SIZE     = 1024
+	DEFAULT_SCALE_LATENCY       = 100 * time.Millisecond
+	DEFAULT_WORKER_IDLE_TIMEOUT = 60 * time.Second
+)
+
+type RejectHandler func(rq IRequest)
+
+//工作池排队中的请求
+type task struct {
+	rq       IRequest
+	queuedAt time.Time //入队时间，用于计算排队延迟
+}
+
+//设置任务队列长度、队列满时的处理策略及busy策略回复的数据，需在启动之前设置
+func (h *Handler) SetTaskQueue(size int, policy string, busyReply []byte) {
+	if size <= 0 {
+		size = DEFAULT_TASK_QUEUE_SIZE
+	}
+	switch policy {
+	case "":
+		policy = RejectBlock
+	case RejectBlock, RejectDrop, RejectBusy:
+	default:
+		Log.Error("unsupported reject policy %s, use %s", policy, RejectBlock)
+		policy = RejectBlock
+	}
+	h.queueSize = size
+	h.rejectPolicy = policy
+	h.busyReply = busyReply
+}
+
+//设置弹性扩容，max大于工作池大小时共享队列排队超过latency则扩容，扩容的工作协程空闲超过idleTimeout退出
+//扩容的工作协程只处理共享队列，affinity分发策略下不扩容，round-robin及least-loaded策略下工作协程的队列满后进入共享队列
+func (h *Handler) SetWorkPoolScale(max uint32, latency time.Duration, idleTimeout time.Duration) {
+	if latency <= 0 {
+		latency = DEFAULT_SCALE_LATENCY
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DEFAULT_WORKER_IDLE_TIMEOUT
+	}
+	h.maxWorkers = max
+	h.scaleLatency = latency
+	h.workerIdleTimeout = idleTimeout
+}
+
+//设置请求被拒绝时的回调
+func (h *Handler) OnReject(fn RejectHandler) {
+	h.onReject = fn
+}
+
+//执行任务，记录共享队列的排队延迟
+func (h *Handler) runTask(id uint32, t *task, shared bool) {
+	if shared {
+		atomic.StoreInt64(&h.sharedLatency, int64(time.Since(t.queuedAt)))
+		atomic.StoreInt64(&h.sharedDequeued, time.Now().UnixNano())
+	}
+	t.rq.SetWorkId(id)
+	h.RunHandler(t.rq)
+	atomic.AddInt64(&h.inflight, -1)
+	atomic.AddUint64(&h.completed, 1)
+}
+
+//扩容的工作协程，只处理共享队列，空闲超时后退出
+func (h *Handler) runExtraWork(id uint32) {
+	timer := time.NewTimer(h.workerIdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case t := <-h.shared:
+			h.runTask(id, t, true)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(h.workerIdleTimeout)
+		case <-timer.C:
+			atomic.AddInt32(&h.workers, -1)
+			Log.Info("[WorkPool] extra worker %d exit, workers %d", id, atomic.LoadInt32(&h.workers))
+			return
+		}
+	}
+}
+
+//共享队列排队延迟超过阈值时扩容
+func (h *Handler) autoScale() {
+	ticker := time.NewTicker(h.scaleLatency)
+	defer ticker.Stop()
+	for range ticker.C {
+		if len(h.shared) == 0 {
+			continue
+		}
+		dequeued := time.Unix(0, atomic.LoadInt64(&h.sharedDequeued))
+		if time.Duration(atomic.LoadInt64(&h.sharedLatency)) > h.scaleLatency || time.Since(dequeued) > h.scaleLatency {
+			h.grow()
+		}
+	}
+}
+
+//增加一个工作协程，达到最大数量时不处理
+func (h *Handler) grow() {
+	for {
+		n := atomic.LoadInt32(&h.workers)
+		if n >= int32(h.maxWorkers) {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&h.workers, n, n+1) {
+			id := h.workpoolSize + atomic.AddUint32(&h.extraId, 1) - 1
+			go h.runExtraWork(id)
+			Log.Info("[WorkPool] queue latency too high, start extra worker %d, workers %d", id, n+1)
+			return
+		}
+	}
+}
+
+//放入队列，非block策略下队列满时拒绝请求，返回是否已放入
+func (h *Handler) enqueue(ch chan *task, t *task) bool {
+	if h.rejectPolicy == RejectBlock {
+		ch <- t
+		return true
+	}
+	select {
+	case ch <- t:
+		return true
+	default:
+		h.reject(t.rq)
+		return false
+	}
+}
+
+//拒绝请求
+func (h *Handler) reject(rq IRequest) {
+	atomic.AddInt64(&h.inflight, -1)
+	atomic.AddUint64(&h.rejected, 1)
+	//在读取协程中执行，不阻塞等待发送队列，发送失败时丢弃busy回复
+	if h.rejectPolicy == RejectBusy {
+		if err := trySendMessage(rq.GetConnection(), replyMessage(rq, h.busyReply)); err != nil {
+			Log.Warning("drop busy reply err:%s, ConnID = %d", err.Error(), rq.GetConnection().GetID())
+		}
+	}
+	if h.onReject != nil {
+		h.safeRun(rq, func() {
+			h.onReject(rq)
+		})
+	}
+}